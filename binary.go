@@ -0,0 +1,322 @@
+package htm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// The binary format of an HTM is little-endian with fixed-width records, each section aligned to
+// eight bytes so that it may be read in place, such as from a memory mapped file.
+//
+//	header        64 bytes: magic "HTM\x00", version uint32, roots uint32, reserved uint32,
+//	              then vertex, tree, edge, free tree and free vertex counts as uint64, reserved uint64
+//	vertices      X, Y, Z float64
+//	trees         level int32, flags uint32, indices [3]int32, children [4]int32, parent int32
+//	edges         start, end, mid int32, in the order of their groups
+//	free trees    int32
+//	free vertices int32
+//	checksum      CRC-32 (IEEE) of all preceding bytes as uint32, reserved uint32
+//
+// A tree's index is its position. Midpoint, Surface and OnChange are not encoded.
+const (
+	binaryMagic   = "HTM\x00"
+	binaryVersion = 1
+
+	headerSize = 64
+	vertexSize = 24
+	treeSize   = 40
+	edgeSize   = 12
+)
+
+// header describes the sections of an encoded HTM.
+type header struct {
+	roots                                 int
+	vertices, trees, edges, freeT, freeV  int
+	vertexOff, treeOff, edgeOff, freeTOff int
+	freeVOff, checksumOff, size           int
+}
+
+// align8 rounds n up to a multiple of eight.
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// layout computes the offsets of each section from the counts of hd.
+func (hd *header) layout() {
+	hd.vertexOff = headerSize
+	hd.treeOff = hd.vertexOff + hd.vertices*vertexSize
+	hd.edgeOff = hd.treeOff + hd.trees*treeSize
+	hd.freeTOff = align8(hd.edgeOff + hd.edges*edgeSize)
+	hd.freeVOff = align8(hd.freeTOff + hd.freeT*4)
+	hd.checksumOff = align8(hd.freeVOff + hd.freeV*4)
+	hd.size = hd.checksumOff + 8
+}
+
+// readHeader parses and verifies the header and checksum of data.
+func readHeader(data []byte) (header, error) {
+	var hd header
+	if len(data) < headerSize+8 {
+		return hd, fmt.Errorf("data too short")
+	}
+	if string(data[:4]) != binaryMagic {
+		return hd, fmt.Errorf("invalid magic %q", data[:4])
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != binaryVersion {
+		return hd, fmt.Errorf("unsupported version %v", v)
+	}
+	hd.roots = int(binary.LittleEndian.Uint32(data[8:]))
+	counts := []*int{&hd.vertices, &hd.trees, &hd.edges, &hd.freeT, &hd.freeV}
+	for i, c := range counts {
+		n := binary.LittleEndian.Uint64(data[16+i*8:])
+		if n > uint64(len(data)) {
+			return hd, fmt.Errorf("section %v of %v records exceeds data", i, n)
+		}
+		*c = int(n)
+	}
+	hd.layout()
+	if hd.size != len(data) {
+		return hd, fmt.Errorf("expected %v bytes but have %v", hd.size, len(data))
+	}
+	if hd.roots == 0 || hd.roots > hd.trees {
+		return hd, fmt.Errorf("invalid root count %v", hd.roots)
+	}
+	if sum := binary.LittleEndian.Uint32(data[hd.checksumOff:]); sum != crc32.ChecksumIEEE(data[:hd.checksumOff]) {
+		return hd, fmt.Errorf("checksum mismatch")
+	}
+	return hd, nil
+}
+
+// MarshalBinary encodes h including its empty trees and vertices held for reuse, so that it
+// decodes to an identical HTM.
+func (h *HTM) MarshalBinary() ([]byte, error) {
+	edges := h.Edges.nonempty()
+	hd := header{
+		roots:    h.roots,
+		vertices: len(h.Vertices),
+		trees:    len(h.Trees),
+		edges:    len(edges),
+		freeT:    len(h.freeTrees),
+		freeV:    len(h.freeVertices),
+	}
+	for _, n := range []int{hd.vertices, hd.trees} {
+		if n > math.MaxInt32 {
+			return nil, fmt.Errorf("%v records exceed format", n)
+		}
+	}
+	hd.layout()
+
+	le := binary.LittleEndian
+	data := make([]byte, hd.size)
+	copy(data, binaryMagic)
+	le.PutUint32(data[4:], binaryVersion)
+	le.PutUint32(data[8:], uint32(hd.roots))
+	for i, n := range []int{hd.vertices, hd.trees, hd.edges, hd.freeT, hd.freeV} {
+		le.PutUint64(data[16+i*8:], uint64(n))
+	}
+
+	b := data[hd.vertexOff:]
+	for _, v := range h.Vertices {
+		le.PutUint64(b, math.Float64bits(v.X))
+		le.PutUint64(b[8:], math.Float64bits(v.Y))
+		le.PutUint64(b[16:], math.Float64bits(v.Z))
+		b = b[vertexSize:]
+	}
+	for _, t := range h.Trees {
+		le.PutUint32(b, uint32(int32(t.Level)))
+		le.PutUint32(b[4:], uint32(t.Flags))
+		for k, i := range t.Indices {
+			le.PutUint32(b[8+k*4:], uint32(int32(i)))
+		}
+		for k, c := range t.Children {
+			le.PutUint32(b[20+k*4:], uint32(int32(c)))
+		}
+		le.PutUint32(b[36:], uint32(int32(t.Parent)))
+		b = b[treeSize:]
+	}
+	for _, e := range edges {
+		le.PutUint32(b, uint32(int32(e.Start)))
+		le.PutUint32(b[4:], uint32(int32(e.End)))
+		le.PutUint32(b[8:], uint32(int32(e.Mid)))
+		b = b[edgeSize:]
+	}
+	for i, x := range h.freeTrees {
+		le.PutUint32(data[hd.freeTOff+i*4:], uint32(int32(x)))
+	}
+	for i, x := range h.freeVertices {
+		le.PutUint32(data[hd.freeVOff+i*4:], uint32(int32(x)))
+	}
+	le.PutUint32(data[hd.checksumOff:], crc32.ChecksumIEEE(data[:hd.checksumOff]))
+	return data, nil
+}
+
+// UnmarshalBinary decodes data produced by MarshalBinary into h, replacing its contents. Midpoint,
+// Surface and OnChange are kept.
+func (h *HTM) UnmarshalBinary(data []byte) error {
+	hd, err := readHeader(data)
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	i32 := func(off int) int { return int(int32(le.Uint32(data[off:]))) }
+	inRange := func(i, n int) bool { return i >= 0 && i < n }
+
+	vertices := make([]Vec3, hd.vertices)
+	for i := range vertices {
+		vertices[i] = decodeVertex(data[hd.vertexOff+i*vertexSize:])
+	}
+
+	trees := make([]Tree, hd.trees)
+	for i := range trees {
+		t := decodeTree(data[hd.treeOff+i*treeSize:], i)
+		if err := hd.check(t); err != nil {
+			return err
+		}
+		if t.Empty() {
+			t = Tree{}
+		}
+		trees[i] = t
+	}
+
+	if err := checkTrees(len(trees), func(idx int) Tree { return trees[idx] }); err != nil {
+		return err
+	}
+
+	ed := &Edges{}
+	for i := 0; i < hd.edges; i++ {
+		off := hd.edgeOff + i*edgeSize
+		start, end, mid := i32(off), i32(off+4), i32(off+8)
+		if !inRange(start, hd.vertices) || !inRange(end, hd.vertices) || start == end || (mid != -1 && !inRange(mid, hd.vertices)) {
+			return fmt.Errorf("invalid edge (%v, %v, %v)", start, end, mid)
+		}
+		e, _ := ed.edge(start, end)
+		e.Mid = mid
+	}
+
+	// free entries are handed out again by newTree and newVertex, so each must be unused and listed once
+	x := &HTM{Vertices: vertices, Trees: trees}
+	x.countRefs()
+	seen := make([]bool, hd.trees)
+	freeTrees := make([]int, hd.freeT)
+	for i := range freeTrees {
+		idx := i32(hd.freeTOff + i*4)
+		switch {
+		case !inRange(idx, hd.trees):
+			return fmt.Errorf("free tree %v out of range", idx)
+		case !trees[idx].Empty():
+			return fmt.Errorf("free tree %v is live", idx)
+		case seen[idx]:
+			return fmt.Errorf("free tree %v listed twice", idx)
+		}
+		seen[idx], freeTrees[i] = true, idx
+	}
+	seen = make([]bool, hd.vertices)
+	freeVertices := make([]int, hd.freeV)
+	for i := range freeVertices {
+		v := i32(hd.freeVOff + i*4)
+		switch {
+		case !inRange(v, hd.vertices):
+			return fmt.Errorf("free vertex %v out of range", v)
+		case x.refs[v] != 0:
+			return fmt.Errorf("free vertex %v is referenced", v)
+		case seen[v]:
+			return fmt.Errorf("free vertex %v listed twice", v)
+		}
+		seen[v], freeVertices[i] = true, v
+	}
+
+	h.Edges, h.Vertices, h.Trees, h.roots = ed, vertices, trees, hd.roots
+	h.freeTrees, h.freeVertices, h.refs = freeTrees, freeVertices, x.refs
+	return nil
+}
+
+// check returns an error if a live tree refers to vertices or trees out of range.
+func (hd *header) check(t Tree) error {
+	if t.Empty() {
+		return nil
+	}
+	for _, x := range t.Indices {
+		if x < 0 || x >= hd.vertices {
+			return fmt.Errorf("tree %v indice %v out of range", t.Index, x)
+		}
+	}
+	if !t.Leaf() {
+		for _, c := range t.Children {
+			if c < 0 || c >= hd.trees {
+				return fmt.Errorf("tree %v child %v out of range", t.Index, c)
+			}
+		}
+	}
+	if t.Parent != -1 && (t.Parent < 0 || t.Parent >= hd.trees) {
+		return fmt.Errorf("tree %v parent %v out of range", t.Index, t.Parent)
+	}
+	return nil
+}
+
+// checkTrees returns an error unless each live child is one level below its parent and belongs to
+// that parent alone, so that traversals of trees from untrusted data end and visit each node once.
+func checkTrees(n int, tree func(idx int) Tree) error {
+	seen := make([]bool, n)
+	for idx := 0; idx < n; idx++ {
+		t := tree(idx)
+		if t.Empty() || t.Leaf() {
+			continue
+		}
+		for _, c := range t.Children {
+			if x := tree(c); x.Empty() || x.Level != t.Level+1 {
+				return fmt.Errorf("tree %v child %v at level %v", idx, c, x.Level)
+			} else if x.Parent != idx || seen[c] {
+				return fmt.Errorf("tree %v child %v has another parent", idx, c)
+			}
+			seen[c] = true
+		}
+	}
+	return nil
+}
+
+// decodeVertex decodes the vertex record at the start of b.
+func decodeVertex(b []byte) Vec3 {
+	le := binary.LittleEndian
+	return Vec3{
+		math.Float64frombits(le.Uint64(b)),
+		math.Float64frombits(le.Uint64(b[8:])),
+		math.Float64frombits(le.Uint64(b[16:])),
+	}
+}
+
+// decodeTree decodes the tree record at the start of b as the tree at idx.
+func decodeTree(b []byte, idx int) Tree {
+	le := binary.LittleEndian
+	i32 := func(off int) int { return int(int32(le.Uint32(b[off:]))) }
+	t := Tree{Index: idx, Level: i32(0), Flags: TreeFlags(le.Uint32(b[4:])), Parent: i32(36)}
+	for k := range t.Indices {
+		t.Indices[k] = i32(8 + k*4)
+	}
+	for k := range t.Children {
+		t.Children[k] = i32(20 + k*4)
+	}
+	return t
+}
+
+// WriteTo writes the binary encoding of h to w.
+func (h *HTM) WriteTo(w io.Writer) (int64, error) {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadFrom reads the binary encoding of an HTM from r until EOF into h.
+func (h *HTM) ReadFrom(r io.Reader) (int64, error) {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	return n, h.UnmarshalBinary(buf.Bytes())
+}
@@ -0,0 +1,120 @@
+package htm
+
+import "sort"
+
+// ChangeKind identifies how an operation changed the mesh.
+type ChangeKind uint8
+
+const (
+	// TriangleAdded reports a node that became a leaf, by index into Trees.
+	TriangleAdded ChangeKind = iota
+
+	// TriangleRemoved reports a leaf that was split or culled, by index into Trees.
+	TriangleRemoved
+
+	// VertexAdded reports a new vertex, by index into Vertices.
+	VertexAdded
+
+	// VertexRemoved reports a vertex that was emptied for reuse, by index into Vertices.
+	VertexRemoved
+)
+
+// Change is a single change made to the mesh by SubDivide, Cull or any operation built on them.
+type Change struct {
+	Kind  ChangeKind
+	Index int
+}
+
+// change reports a change of the given kind to OnChange if set.
+func (h *HTM) change(kind ChangeKind, index int) {
+	if h.OnChange != nil {
+		h.OnChange(Change{Kind: kind, Index: index})
+	}
+}
+
+// Range is a half-open range of indices [Start, End).
+type Range struct {
+	Start, End int
+}
+
+// ChangeLog records changes for a renderer to patch its buffers instead of uploading them whole.
+// Use Record as OnChange of an HTM. The index buffer is expected to be laid out by SlotIndices so
+// that each tree has a fixed position, and the vertex buffer by Vertices or Positions.
+//
+//	var log ChangeLog
+//	h.OnChange = log.Record
+//	SubDivide(h, idx, 9)
+//	trees, vertices := log.Dirty(0)
+//	log.Reset()
+//
+// Compact renumbers trees and vertices without recording changes; buffers must be uploaded whole
+// after it.
+type ChangeLog struct {
+	Changes []Change
+}
+
+// Record appends c to the log.
+func (l *ChangeLog) Record(c Change) {
+	l.Changes = append(l.Changes, c)
+}
+
+// Reset clears the log, such as after buffers have been patched.
+func (l *ChangeLog) Reset() {
+	l.Changes = l.Changes[:0]
+}
+
+// Dirty returns the sorted ranges of tree slots and vertices changed since the last Reset. Ranges
+// separated by no more than gap indices are joined to reduce the number of uploads.
+func (l *ChangeLog) Dirty(gap int) (trees, vertices []Range) {
+	var ti, vi []int
+	for _, c := range l.Changes {
+		switch c.Kind {
+		case TriangleAdded, TriangleRemoved:
+			ti = append(ti, c.Index)
+		case VertexAdded, VertexRemoved:
+			vi = append(vi, c.Index)
+		}
+	}
+	return ranges(ti, gap), ranges(vi, gap)
+}
+
+// ranges sorts indices and joins them into ranges.
+func ranges(indices []int, gap int) []Range {
+	if len(indices) == 0 {
+		return nil
+	}
+	sort.Ints(indices)
+	rs := []Range{{indices[0], indices[0] + 1}}
+	for _, i := range indices[1:] {
+		r := &rs[len(rs)-1]
+		if i <= r.End+gap {
+			if i >= r.End {
+				r.End = i + 1
+			}
+			continue
+		}
+		rs = append(rs, Range{i, i + 1})
+	}
+	return rs
+}
+
+// SlotIndices returns vertex indices of every tree slot in the same order as Trees, three per
+// tree, so that changes to a tree patch a fixed range of the buffer. Slots of trees that are empty
+// or have children hold degenerate triangles of zeros that draw nothing.
+func (h *HTM) SlotIndices() []uint32 {
+	indices := make([]uint32, len(h.Trees)*3)
+	for idx := range h.Trees {
+		i0, i1, i2 := h.SlotIndicesAt(idx)
+		indices[idx*3], indices[idx*3+1], indices[idx*3+2] = i0, i1, i2
+	}
+	return indices
+}
+
+// SlotIndicesAt returns the indices held by SlotIndices for the tree at idx.
+func (h *HTM) SlotIndicesAt(idx int) (i0, i1, i2 uint32) {
+	t := h.Trees[idx]
+	if t.Empty() || !t.Leaf() {
+		return 0, 0, 0
+	}
+	return uint32(t.Indices[0]), uint32(t.Indices[1]), uint32(t.Indices[2])
+}
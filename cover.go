@@ -0,0 +1,155 @@
+package htm
+
+// CoverMode selects how cells on the boundary of a region are converted between hierarchies, such as
+// trixels, HEALPix pixels or S2 cells.
+type CoverMode int
+
+const (
+	// Conservative includes boundary cells, so the result contains the region.
+	Conservative CoverMode = iota
+
+	// Inner excludes boundary cells, so the region contains the result.
+	Inner
+)
+
+// Hierarchy describes the cells of another hierarchical division of the sphere, such as HEALPix
+// pixels or S2 cells, for converting regions between its cells and the nodes of an HTM.
+type Hierarchy[C any] struct {
+	Roots []C
+
+	// Children returns the cells within c, or nil if c is not divided further.
+	Children func(c C) []C
+
+	// Intersects reports whether c overlaps the triangle v0, v1, v2 with great circle edges. It may
+	// report cells that only nearly touch the triangle, which makes conversions less tight.
+	Intersects func(c C, v0, v1, v2 Vec3) bool
+}
+
+// RegionCells returns the region of the nodes of h at the given indices, such as the result of
+// Intersections, as cells of x. Cells within the region are returned without their descendants, and
+// the mode selects whether cells without children on its boundary are included.
+func RegionCells[C any](h *HTM, cover []int, x Hierarchy[C], mode CoverMode) []C {
+	// 1 for nodes in the cover and 2 for their ancestors
+	mark := make([]uint8, len(h.Trees))
+	for _, idx := range cover {
+		mark[idx] = 1
+	}
+	for _, idx := range cover {
+		for p := h.Trees[idx].Parent; p != -1 && mark[p] == 0; p = h.Trees[p].Parent {
+			mark[p] = 2
+		}
+	}
+
+	// touches reports whether c touches the region and its complement
+	touches := func(c C) (in, out bool) {
+		var visit func(idx int)
+		visit = func(idx int) {
+			if in && out {
+				return
+			}
+			if v0, v1, v2 := h.VerticesAt(idx); !x.Intersects(c, v0, v1, v2) {
+				return
+			}
+			t := h.Trees[idx]
+			switch {
+			case mark[idx] == 1:
+				in = true
+			case mark[idx] == 0 || t.Leaf():
+				out = true
+			default:
+				for _, c := range t.Children {
+					visit(c)
+				}
+			}
+		}
+		for r := 0; r < h.roots; r++ {
+			if !h.Trees[r].Empty() {
+				visit(r)
+			}
+		}
+		return in, out
+	}
+
+	var cells []C
+	var visit func(c C)
+	visit = func(c C) {
+		in, out := touches(c)
+		switch {
+		case !in:
+		case !out:
+			cells = append(cells, c)
+		default:
+			children := x.Children(c)
+			if children == nil && mode == Conservative {
+				cells = append(cells, c)
+			}
+			for _, c := range children {
+				visit(c)
+			}
+		}
+	}
+	for _, c := range x.Roots {
+		visit(c)
+	}
+	return cells
+}
+
+// RegionCover returns the nodes of h in a region of cells of x, as Intersections would for a Tester.
+// The status of a cell is Inside or Outside if it is wholly within or outside the region, and
+// Partial otherwise. Nodes within the region are returned without their descendants, and the mode
+// selects whether leaves on its boundary are included.
+func RegionCover[C any](h *HTM, x Hierarchy[C], status func(c C) Coverage, mode CoverMode) []int {
+	// touches reports whether the triangle touches the region and its complement
+	touches := func(v0, v1, v2 Vec3) (in, out bool) {
+		var visit func(c C)
+		visit = func(c C) {
+			if in && out || !x.Intersects(c, v0, v1, v2) {
+				return
+			}
+			switch status(c) {
+			case Inside:
+				in = true
+			case Outside:
+				out = true
+			default:
+				children := x.Children(c)
+				if children == nil {
+					in, out = true, true
+				}
+				for _, c := range children {
+					visit(c)
+				}
+			}
+		}
+		for _, c := range x.Roots {
+			visit(c)
+		}
+		return in, out
+	}
+
+	var cover []int
+	var visit func(idx int)
+	visit = func(idx int) {
+		t := h.Trees[idx]
+		in, out := touches(h.VerticesAt(idx))
+		switch {
+		case !in:
+		case !out:
+			cover = append(cover, idx)
+		case t.Leaf():
+			if mode == Conservative {
+				cover = append(cover, idx)
+			}
+		default:
+			for _, c := range t.Children {
+				visit(c)
+			}
+		}
+	}
+	for r := 0; r < h.roots; r++ {
+		if !h.Trees[r].Empty() {
+			visit(r)
+		}
+	}
+	return cover
+}
@@ -1,21 +1,27 @@
 package htm
 
-// Edge holdes indices of three vertices representing a line.
+import "fmt"
+
+// Edge holdes indices of three vertices representing a line. Mid is -1 until the line is subdivided.
 type Edge struct {
 	Start, End, Mid int
 }
 
-// Empty returns true if edge is zero value.
+// Empty returns true if edge does not represent a line, such as the zero value. Any vertex, including
+// vertex zero, may be the start, end or midpoint of a valid edge.
 func (e Edge) Empty() bool {
-	return e.Start == 0 && e.End == 0 && e.Mid == 0
+	return e.Start == e.End
 }
 
 func (e Edge) Equals(x Edge) bool {
 	return e.Start == x.Start && e.End == x.End && e.Mid == x.Mid
 }
 
-// Edges is a container for Edge where the end of an edge has at most six neighbors, and so edges
-// are stored sorted by their HTM indices in ordered groups of six.
+// Edges is an adjacency container for Edge where edges are stored sorted by their HTM indices in ordered
+// groups, one group per vertex holding the edges to its lower indexed neighbors. A group holds six edges
+// inline, enough for any vertex created by subdivision of the octahedron. There is no limit on the number
+// of edges of a vertex; those beyond six, such as for a vertex of an arbitrary base mesh or one reused
+// after a cull, are kept in an overflow list for that vertex.
 //
 // TODO(d) This would grow out of control with each subdivision if topology was being maintained during
 // subdivision. Normaly a Match(start, end) is going to receive a vertex indice and then use that to lookup
@@ -32,75 +38,172 @@ func (e Edge) Equals(x Edge) bool {
 // neighboring faces to subdivide a minimum number of times to maintain continuity. ltree may help with this.
 type Edges struct {
 	slice     []Edge
-	bootstrap [9444]Edge // memory to hold first slice; Helps avoid allocation for L5 and below.
+	overflow  map[int][]Edge // edges beyond the sixth of a group, keyed by start
+	bootstrap [9444]Edge     // memory to hold first slice; Helps avoid allocation for L5 and below.
+}
+
+// validate returns an error if start and end do not represent a line between two vertices.
+func validate(start, end int) error {
+	if start < 0 || end < 0 {
+		return fmt.Errorf("edge (%v, %v) has negative indice", start, end)
+	}
+	if start == end {
+		return fmt.Errorf("edge (%v, %v) is degenerate", start, end)
+	}
+	return nil
 }
 
 // Init locates an Edge with given start and end indices, or initializes one otherwise. The order of start and
-// end do not matter as long as they represent a line with a potential midpoint in the data structure. If a match is found,
-// the midpoint indice of the edge is returned and can be used to look up a vertex in an HTM. The edge index is
-// also returned whether matched or initialized. Finally, a bool if the match is sucessful is returned.
-func (ed *Edges) Init(start, end int) (int, bool) {
-	if start < end {
-		start, end = end, start
+// end do not matter as long as they represent a line with a potential midpoint in the data structure. Returns
+// true if the edge was initialized, or false if it already existed.
+func (ed *Edges) Init(start, end int) (bool, error) {
+	if err := validate(start, end); err != nil {
+		return false, err
 	}
+	_, ok := ed.edge(start, end)
+	return ok, nil
+}
+
+// Mid returns the midpoint indice of the edge with given start and end, which can be used to look up a
+// vertex in an HTM. Returns false if the edge does not exist or has not been subdivided.
+func (ed *Edges) Mid(start, end int) (int, bool) {
+	if x := ed.find(start, end); x != nil && x.Mid != -1 {
+		return x.Mid, true
+	}
+	return -1, false
+}
+
+// SetMid sets the midpoint indice of an initialized edge.
+func (ed *Edges) SetMid(start, end, mid int) error {
+	if err := validate(start, end); err != nil {
+		return err
+	}
+	x := ed.find(start, end)
+	if x == nil {
+		return fmt.Errorf("edge (%v, %v) not initialized", start, end)
+	}
+	x.Mid = mid
+	return nil
+}
+
+// Len returns the number of edges.
+func (ed *Edges) Len() int {
+	n := 0
+	for _, x := range ed.slice {
+		if !x.Empty() {
+			n++
+		}
+	}
+	for _, o := range ed.overflow {
+		n += len(o)
+	}
+	return n
+}
+
+// group returns the inline edges of start, growing the container as needed.
+func (ed *Edges) group(start int) []Edge {
 	ed.grow(start)
 	offset := start * 6
-	for i, x := range ed.slice[offset : offset+6] {
-		idx := offset + i
+	return ed.slice[offset : offset+6]
+}
+
+// edge locates the edge with given start and end, initializing it if needed, and reports if it was
+// initialized. The returned pointer is valid until the next edge is initialized or removed.
+func (ed *Edges) edge(start, end int) (*Edge, bool) {
+	return ed.edgeIn(&ed.overflow, start, end)
+}
+
+// edgeIn is edge with overflow edges kept in the given map, allowing a caller that alone writes
+// the groups of some vertices to hold their overflow apart from other callers.
+func (ed *Edges) edgeIn(overflow *map[int][]Edge, start, end int) (*Edge, bool) {
+	if start < end {
+		start, end = end, start
+	}
+	g := ed.group(start)
+	for i, x := range g {
 		if x.Empty() {
-			ed.slice[idx].Start = start
-			ed.slice[idx].End = end
-			return idx, true
+			g[i] = Edge{Start: start, End: end, Mid: -1}
+			return &g[i], true
 		}
 		if x.End == end {
-			return idx, false
+			return &g[i], false
 		}
 	}
-	panic("edge init failure")
-}
-
-func (ed *Edges) traceDeleteMid(mid int, mids *[]int) {
-	offset := mid * 6
-	for _, x := range ed.slice[offset : offset+6] {
-		if x.Empty() {
-			return
-		} else if x.Mid != 0 {
-			*mids = append(*mids, x.Mid)
-			ed.traceDeleteMid(x.Mid, mids)
-			ed.zeroStart(x.Mid)
+	o := (*overflow)[start]
+	for i, x := range o {
+		if x.End == end {
+			return &o[i], false
 		}
-		// TODO(d) maybe could zero out edge here instead of calling zeroStart above
 	}
+	if *overflow == nil {
+		*overflow = make(map[int][]Edge)
+	}
+	o = append(o, Edge{Start: start, End: end, Mid: -1})
+	(*overflow)[start] = o
+	return &o[len(o)-1], true
 }
 
-func (ed *Edges) Merge(start, end int) ([]int, bool) {
+// find returns the edge with given start and end, or nil if it has not been initialized.
+func (ed *Edges) find(start, end int) *Edge {
 	if start < end {
 		start, end = end, start
 	}
-	ed.grow(start)
 	offset := start * 6
-	for i, x := range ed.slice[offset : offset+6] {
-		if x.End == end && x.Mid != 0 {
-			mid := x.Mid
-			x.Mid = 0
-			ed.slice[offset+i] = x
-
-			mids := []int{mid}
-			ed.traceDeleteMid(mid, &mids)
-			ed.zeroStart(mid)
-			return mids, true
+	if start < 0 || offset+6 > len(ed.slice) {
+		return nil
+	}
+	g := ed.slice[offset : offset+6]
+	for i, x := range g {
+		if x.Empty() {
+			return nil
+		}
+		if x.End == end {
+			return &g[i]
+		}
+	}
+	o := ed.overflow[start]
+	for i, x := range o {
+		if x.End == end {
+			return &o[i]
 		}
 	}
-	return nil, false
+	return nil
 }
 
-// zeroStart will replace any edge where Edge.Start == start with empty struct.
-func (ed *Edges) zeroStart(start int) {
-	ed.grow(start)
-	offset := start * 6
-	for i := range ed.slice[offset : offset+6] {
-		ed.slice[offset+i] = Edge{}
+// mid returns the midpoint indice of the edge with given start and end, or -1 if the edge has not
+// been subdivided. Unlike Init, an edge is never initialized.
+func (ed *Edges) mid(start, end int) int {
+	m, _ := ed.Mid(start, end)
+	return m
+}
+
+// remove deletes the edge with given start and end if found. Inline edges are kept packed, taking the
+// last overflow edge of the group if any, so that no empty edge precedes a valid one.
+func (ed *Edges) remove(start, end int) {
+	x := ed.find(start, end)
+	if x == nil {
+		return
+	}
+	if start < end {
+		start, end = end, start
+	}
+	o := ed.overflow[start]
+	if len(o) > 0 {
+		*x = o[len(o)-1]
+		if len(o) == 1 {
+			delete(ed.overflow, start)
+		} else {
+			ed.overflow[start] = o[:len(o)-1]
+		}
+		return
 	}
+	g := ed.group(start)
+	last := 0
+	for last+1 < len(g) && !g[last+1].Empty() {
+		last++
+	}
+	*x = g[last]
+	g[last] = Edge{}
 }
 
 func (ed *Edges) grow(n int) {
@@ -118,12 +221,16 @@ func (ed *Edges) grow(n int) {
 	}
 }
 
+// nonempty returns all edges ordered by start, inline edges of a group before its overflow.
 func (ed *Edges) nonempty() []Edge {
 	var n []Edge
-	for _, x := range ed.slice {
+	for i, x := range ed.slice {
 		if !x.Empty() {
 			n = append(n, x)
 		}
+		if i%6 == 5 {
+			n = append(n, ed.overflow[i/6]...)
+		}
 	}
 	return n
 }
@@ -1,6 +1,6 @@
 package htm
 
-import "github.com/azul3d/engine/lmath"
+import "slices"
 
 // Intersections returns a slice of node indexes that are inside completely or partially.
 //
@@ -33,8 +33,13 @@ func Intersections(h *HTM, idx int, t Tester, mt *[]int) {
 }
 
 // Vec3Inside tests if vector is contained within bounds of triangle.
-func Vec3Inside(h *HTM, idx int, v lmath.Vec3) bool {
+func Vec3Inside(h *HTM, idx int, v Vec3) bool {
 	v0, v1, v2 := h.VerticesAt(idx)
+	return inside(v0, v1, v2, v)
+}
+
+// inside tests if v is contained within the triangle v0, v1, v2.
+func inside(v0, v1, v2, v Vec3) bool {
 	a := v0.Cross(v1).Dot(v)
 	b := v1.Cross(v2).Dot(v)
 	c := v2.Cross(v0).Dot(v)
@@ -43,7 +48,7 @@ func Vec3Inside(h *HTM, idx int, v lmath.Vec3) bool {
 
 // LookupByCart recurses nodes by subdivisions and tests if vector is inside
 // triangle. Locates the single, smallest subdivision that matches.
-func LookupByCart(h *HTM, idx int, v lmath.Vec3, i *int) {
+func LookupByCart(h *HTM, idx int, v Vec3, i *int) {
 	if h.Trees[idx].Empty() {
 		return
 	}
@@ -60,11 +65,10 @@ func LookupByCart(h *HTM, idx int, v lmath.Vec3, i *int) {
 	}
 }
 
-// TODO(d) this seems really quite arbitrarily simple. Take two vertices, add them together, normalize.
-// Since the initial structure is an octahedron, this simply works out to make a sphere, but the process
-// seems like it would work fine for arbitrary refinement.
+// SubDivide recursively splits the node at idx and its descendants until they reach the given level.
+// New vertices are placed by the HTM's midpoint rule.
 //
-// The important part would be my notes on Edges struct, and maintaining continuity with neighbor faces by
+// TODO(d) The important part would be my notes on Edges struct, and maintaining continuity with neighbor faces by
 // triggering minimum subdivisions there with an easy way to traverse and locate neighbors.
 //
 // Or automagically updating their indices if such a thing could also keep the master indices that makes its
@@ -89,45 +93,45 @@ func SubDivide(h *HTM, idx int, level int) {
 
 	// check each edge to see if it has already been subdivided due to a neighboring face
 	// subdivision that has already performed the calculation.
-	getMid := func(i0, i1 int, v0, v1 lmath.Vec3) int {
-		eidx, _ := h.Edges.Init(i0, i1)
-		if h.Edges.slice[eidx].Mid == 0 {
-			w0, _ := v0.Add(v1).Normalized()
-			h.Vertices = append(h.Vertices, w0)
-			e0 := len(h.Vertices) - 1
-			h.Edges.slice[eidx].Mid = e0
+	getMid := func(i0, i1 int, v0, v1 Vec3) int {
+		e, _ := h.Edges.edge(i0, i1)
+		if e.Mid == -1 {
+			e.Mid = h.newVertex(h.midpoint(v0, v1))
 		}
-		return h.Edges.slice[eidx].Mid
+		return e.Mid
 	}
 
 	e0 := getMid(i1, i2, v1, v2)
 	e1 := getMid(i0, i2, v0, v2)
 	e2 := getMid(i0, i1, v0, v1)
 
-	i := len(h.Trees)
-	a, b, c, d := i, i+1, i+2, i+3
 	l := h.LevelAt(idx) + 1
-
-	h.Trees = append(h.Trees,
-		Tree{Index: a, Level: l, Indices: [3]int{i0, e2, e1}, Parent: idx}, // v0, w2, w1
-		Tree{Index: b, Level: l, Indices: [3]int{i1, e0, e2}, Parent: idx}, // v1, w0, w2
-		Tree{Index: c, Level: l, Indices: [3]int{i2, e1, e0}, Parent: idx}, // v2, w1, w0
-		Tree{Index: d, Level: l, Indices: [3]int{e0, e1, e2}, Parent: idx}) // w0, w1, w2
+	a := h.newTree(Tree{Level: l, Indices: [3]int{i0, e2, e1}, Parent: idx}) // v0, w2, w1
+	b := h.newTree(Tree{Level: l, Indices: [3]int{i1, e0, e2}, Parent: idx}) // v1, w0, w2
+	c := h.newTree(Tree{Level: l, Indices: [3]int{i2, e1, e0}, Parent: idx}) // v2, w1, w0
+	d := h.newTree(Tree{Level: l, Indices: [3]int{e0, e1, e2}, Parent: idx}) // w0, w1, w2
 
 	h.Trees[idx].Children = [4]int{a, b, c, d}
+	h.Trees[idx].Flags |= TreeSplit
+	h.addRefs(h.Trees[idx], -1)
+	h.change(TriangleRemoved, idx)
+	for _, x := range h.Trees[idx].Children {
+		h.addRefs(h.Trees[x], 1)
+		h.change(TriangleAdded, x)
+	}
 
-	h.Edges.Init(e2, e1)
-	h.Edges.Init(i0, e1)
-	h.Edges.Init(i0, e2)
-	h.Edges.Init(e0, e2)
-	h.Edges.Init(i1, e2)
-	h.Edges.Init(i1, e0)
-	h.Edges.Init(e1, e0)
-	h.Edges.Init(i2, e0)
-	h.Edges.Init(i2, e1)
-	h.Edges.Init(e1, e2)
-	h.Edges.Init(e0, e2)
-	h.Edges.Init(e0, e1)
+	h.Edges.edge(e2, e1)
+	h.Edges.edge(i0, e1)
+	h.Edges.edge(i0, e2)
+	h.Edges.edge(e0, e2)
+	h.Edges.edge(i1, e2)
+	h.Edges.edge(i1, e0)
+	h.Edges.edge(e1, e0)
+	h.Edges.edge(i2, e0)
+	h.Edges.edge(i2, e1)
+	h.Edges.edge(e1, e2)
+	h.Edges.edge(e0, e2)
+	h.Edges.edge(e0, e1)
 
 	SubDivide(h, a, level)
 	SubDivide(h, b, level)
@@ -135,113 +139,101 @@ func SubDivide(h *HTM, idx int, level int) {
 	SubDivide(h, d, level)
 }
 
-func lowestLevel(h *HTM, idx int, lvl *int) {
-	if h.Trees[idx].Children[0] == 0 {
-		*lvl = h.Trees[idx].Level
-	} else {
-		lowestLevel(h, h.Trees[idx].Children[0], lvl)
-	}
-}
-
-func merge(h *HTM, i0, i1 int) {
-	if mids, ok := h.Edges.Merge(i0, i1); ok {
-		for _, mid := range mids {
-			h.Vertices[mid] = lmath.Vec3{0, 0, 0}
-		}
-	}
-}
-
 func CullToLevel(h *HTM, idx int, lvl int) {
-	for _, tidx := range IterLevel(h, idx, lvl) {
-		t := h.Trees[tidx]
-		if t.Level == lvl && t.Children[0] != 0 {
+	for tidx := range AtLevel(h, idx, lvl) {
+		if !h.Trees[tidx].Leaf() {
 			Cull(h, tidx)
 		}
 	}
 }
 
+// Cull removes all descendants of the node at idx, releasing their trees and vertices for reuse by
+// later subdivisions. A midpoint still in use by a subdivided neighbor is kept until that neighbor
+// is culled in turn.
 func Cull(h *HTM, idx int) {
+	t := h.Trees[idx]
+	if t.Leaf() {
+		return
+	}
+	for _, c := range t.Children {
+		Cull(h, c)
+	}
+	mergeLeaves(h, idx)
+}
 
-	var llvl int
-	lowestLevel(h, idx, &llvl)
-
-	emptyChildren := func(tr Tree) {
-		if tr.Children[0] != 0 {
-			h.Trees[tr.Children[0]] = Tree{}
-		}
-		if tr.Children[1] != 0 {
-			h.Trees[tr.Children[1]] = Tree{}
-		}
-		if tr.Children[2] != 0 {
-			h.Trees[tr.Children[2]] = Tree{}
-		}
-		if tr.Children[3] != 0 {
-			h.Trees[tr.Children[3]] = Tree{}
+// childrenAreLeaves reports if the node at idx has children and none of them have children.
+func childrenAreLeaves(h *HTM, idx int) bool {
+	t := h.Trees[idx]
+	if t.Leaf() {
+		return false
+	}
+	for _, c := range t.Children {
+		if !h.Trees[c].Leaf() {
+			return false
 		}
-		tr.Children = [4]int{0, 0, 0, 0}
-		h.Trees[tr.Index] = tr
 	}
+	return true
+}
 
+// mergeLeaves culls the children of the node at idx, which must all be leaves, and returns the
+// number of vertices released. Each midpoint is referenced by three of the children, so any
+// further reference belongs to a subdivided neighbor and the midpoint is kept with its edges.
+func mergeLeaves(h *HTM, idx int) int {
 	t := h.Trees[idx]
-	for curLvl := llvl; curLvl > t.Level; curLvl-- {
-		trees := IterLevel(h, t.Index, curLvl)
-
-		for _, tidx := range trees {
-			tr := h.Trees[tidx]
-			i0, i1, i2 := tr.Indices[0], tr.Indices[1], tr.Indices[2]
-			merge(h, i1, i2)
-			merge(h, i0, i2)
-			merge(h, i0, i1)
-			emptyChildren(tr)
-		}
+	for _, c := range t.Children {
+		h.addRefs(h.Trees[c], -1)
+		h.change(TriangleRemoved, c)
 	}
+	h.addRefs(t, 1)
+	h.change(TriangleAdded, idx)
 
 	i0, i1, i2 := t.Indices[0], t.Indices[1], t.Indices[2]
-	merge(h, i1, i2)
-	merge(h, i0, i2)
-	merge(h, i0, i1)
-	emptyChildren(t)
-}
+	e0, e1, e2 := h.IndicesAt(t.Children[3])
+	h.Edges.remove(e0, e1)
+	h.Edges.remove(e1, e2)
+	h.Edges.remove(e0, e2)
+
+	released := 0
+	for _, x := range [3][3]int{{i1, i2, e0}, {i0, i2, e1}, {i0, i1, e2}} {
+		start, end, mid := x[0], x[1], x[2]
+		if h.refs[mid] != 0 {
+			continue
+		}
+		h.Edges.remove(start, mid)
+		h.Edges.remove(mid, end)
+		if e := h.Edges.find(start, end); e != nil {
+			e.Mid = -1
+		}
+		h.freeVertex(mid)
+		released++
+	}
 
-func iter(h *HTM, pos int, ch chan int) {
-	t := h.Trees[pos]
-	if t.Children[0] == 0 {
-		ch <- pos
-	} else {
-		iter(h, t.Children[0], ch)
-		iter(h, t.Children[1], ch)
-		iter(h, t.Children[2], ch)
-		iter(h, t.Children[3], ch)
+	for _, c := range t.Children {
+		h.freeTree(c)
 	}
+	h.Trees[idx].Children = [4]int{}
+	h.Trees[idx].Flags &^= TreeSplit
+	return released
 }
 
 // Iter accepts a series of node indices and returns a channel that receives node indices
 // of the smallest subdivisions.
+//
+// Deprecated: Use Leaves, which does not leave a goroutine blocked if the receiver stops early.
 func Iter(h *HTM, positions ...int) <-chan int {
 	ch := make(chan int)
 	go func() {
-		for _, pos := range positions {
-			iter(h, pos, ch)
+		for idx := range Leaves(h, positions...) {
+			ch <- idx
 		}
 		close(ch)
 	}()
 	return ch
 }
 
-func iterLevel(h *HTM, idx int, lvl int, indices *[]int) {
-	t := h.Trees[idx]
-	if t.Level == lvl {
-		*indices = append(*indices, idx)
-	} else if t.Children[0] != 0 {
-		iterLevel(h, t.Children[0], lvl, indices)
-		iterLevel(h, t.Children[1], lvl, indices)
-		iterLevel(h, t.Children[2], lvl, indices)
-		iterLevel(h, t.Children[3], lvl, indices)
-	}
-}
-
+// IterLevel returns the node indices at or below idx with the given level.
+//
+// Deprecated: Use AtLevel.
 func IterLevel(h *HTM, idx int, lvl int) []int {
-	var indices []int
-	iterLevel(h, idx, lvl, &indices)
-	return indices
+	return slices.Collect(AtLevel(h, idx, lvl))
 }
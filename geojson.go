@@ -0,0 +1,663 @@
+package htm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+)
+
+// geoStep is the largest angle in radians spanned by a segment of an exported boundary, so that
+// great and small circles are drawn as curves in longitude and latitude.
+const geoStep = math.Pi / 180
+
+// LonLat returns the longitude and latitude in degrees of the direction v.
+func LonLat(v Vec3) (lon, lat float64) {
+	z := v.Z / v.Length()
+	return math.Atan2(v.Y, v.X) * 180 / math.Pi, math.Asin(math.Max(-1, math.Min(1, z))) * 180 / math.Pi
+}
+
+// FromLonLat returns the unit direction at the longitude and latitude in degrees.
+func FromLonLat(lon, lat float64) Vec3 {
+	lon, lat = lon*math.Pi/180, lat*math.Pi/180
+	return Vec3{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+// WriteGeoJSON writes the nodes at the given indices, such as the result of Intersections, as a
+// GeoJSON FeatureCollection of polygons in longitude and latitude with the properties "id" and
+// "level" of each node. Edges are densified to follow great circles, and polygons crossing the
+// antimeridian are split into a MultiPolygon.
+func (h *HTM) WriteGeoJSON(w io.Writer, indices []int) error {
+	features := make([]geoJSONFeature, 0, len(indices))
+	for _, idx := range indices {
+		v0, v1, v2 := h.VerticesAt(idx)
+		var ring []Vec3
+		ring = appendArc(ring, v0, v1)
+		ring = appendArc(ring, v1, v2)
+		ring = appendArc(ring, v2, v0)
+		contains := func(p Vec3) bool { return inside(v0, v1, v2, p) }
+		features = append(features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONPolygons(geoPolygons([][]Vec3{ring}, contains)),
+			Properties: map[string]any{"id": h.ID(idx), "level": h.Trees[idx].Level},
+		})
+	}
+	return writeFeatures(w, features)
+}
+
+// WriteRegionGeoJSON writes a *Constraint, Convex or Domain as a GeoJSON FeatureCollection with a
+// feature for each of its convexes. Boundaries are densified to follow their circles, and split at
+// the antimeridian.
+func WriteRegionGeoJSON(w io.Writer, region Tester) error {
+	var convexes []Convex
+	switch r := region.(type) {
+	case *Constraint:
+		convexes = append(convexes, Convex{r})
+	case Convex:
+		convexes = append(convexes, r)
+	case *Convex:
+		convexes = append(convexes, *r)
+	case Domain:
+		for _, c := range r {
+			convexes = append(convexes, *c)
+		}
+	default:
+		return fmt.Errorf("unsupported region %T", region)
+	}
+	features := make([]geoJSONFeature, 0, len(convexes))
+	for _, c := range convexes {
+		features = append(features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONPolygons(geoPolygons(c.Boundary(), c.contains)),
+			Properties: map[string]any{},
+		})
+	}
+	return writeFeatures(w, features)
+}
+
+func writeFeatures(w io.Writer, features []geoJSONFeature) error {
+	data, err := json.Marshal(map[string]any{"type": "FeatureCollection", "features": features})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// geoJSONPolygons returns a Polygon geometry of a single polygon and a MultiPolygon otherwise.
+func geoJSONPolygons(polys [][][][2]float64) geoJSONGeometry {
+	if len(polys) == 1 {
+		return geoJSONGeometry{"Polygon", polys[0]}
+	}
+	if polys == nil {
+		polys = [][][][2]float64{}
+	}
+	return geoJSONGeometry{"MultiPolygon", polys}
+}
+
+// slerp returns the direction a fraction t along the great circle arc from a to b.
+func slerp(a, b Vec3, t float64) Vec3 {
+	angle := math.Atan2(a.Cross(b).Length(), a.Dot(b))
+	s := math.Sin(angle)
+	if s == 0 {
+		return a
+	}
+	return a.MulScalar(math.Sin((1-t)*angle) / s).Add(b.MulScalar(math.Sin(t*angle) / s))
+}
+
+// appendArc appends the great circle arc from a towards b, excluding b, in segments of at most
+// geoStep.
+func appendArc(ring []Vec3, a, b Vec3) []Vec3 {
+	angle := math.Atan2(a.Cross(b).Length(), a.Dot(b))
+	n := int(math.Ceil(angle / geoStep))
+	if n < 1 {
+		n = 1
+	}
+	for k := 0; k < n; k++ {
+		ring = append(ring, slerp(a, b, float64(k)/float64(n)))
+	}
+	return ring
+}
+
+// circle is the boundary of a constraint's cap, centered at p*d with radius r in the plane
+// spanned by u and w, which turn counterclockwise about p so the cap lies to the left.
+type circle struct {
+	p, u, w Vec3
+	d, r    float64
+}
+
+// circle returns the boundary of c, or false if the cap is empty or the whole sphere.
+func (c *Constraint) circle() (circle, bool) {
+	n := c.P.Length()
+	if n == 0 || c.D/n <= -1 || c.D/n >= 1 {
+		return circle{}, false
+	}
+	p := c.P.DivScalar(n)
+	a := Vec3{1, 0, 0}
+	if math.Abs(p.X) > 0.9 {
+		a = Vec3{0, 1, 0}
+	}
+	u, _ := a.Sub(p.MulScalar(p.Dot(a))).Normalized()
+	d := c.D / n
+	return circle{p: p, u: u, w: p.Cross(u), d: d, r: math.Sqrt(1 - d*d)}, true
+}
+
+func (ci circle) at(t float64) Vec3 {
+	return ci.p.MulScalar(ci.d).Add(ci.u.MulScalar(ci.r * math.Cos(t))).Add(ci.w.MulScalar(ci.r * math.Sin(t)))
+}
+
+// crossings returns the angles at which ci crosses the boundary of c.
+func (ci circle) crossings(c *Constraint) []float64 {
+	n := c.P.Length()
+	if n == 0 {
+		return nil
+	}
+	p := c.P.DivScalar(n)
+	a, b := ci.r*p.Dot(ci.u), ci.r*p.Dot(ci.w)
+	m := math.Hypot(a, b)
+	x := c.D/n - ci.d*p.Dot(ci.p)
+	if m < 1e-12 || math.Abs(x) >= m {
+		return nil
+	}
+	t, dt := math.Atan2(b, a), math.Acos(x/m)
+	return []float64{t - dt, t + dt}
+}
+
+// contains reports whether v is inside all constraints of c.
+func (c Convex) contains(v Vec3) bool {
+	for _, cn := range c {
+		if cn.P.Dot(v) <= cn.D {
+			return false
+		}
+	}
+	return true
+}
+
+// Boundary returns the densified rings bounding the intersection of the caps of c, each with the
+// region on its left.
+func (c Convex) Boundary() [][]Vec3 {
+	type arc struct {
+		ci     circle
+		t0, t1 float64
+	}
+	var arcs []arc
+	var rings [][]Vec3
+	for i, cn := range c {
+		ci, ok := cn.circle()
+		if !ok {
+			continue
+		}
+		var ts []float64
+		for j, other := range c {
+			if j != i {
+				for _, t := range ci.crossings(other) {
+					ts = append(ts, t-2*math.Pi*math.Floor(t/(2*math.Pi)))
+				}
+			}
+		}
+		sort.Float64s(ts)
+		var candidates []arc
+		if len(ts) == 0 {
+			candidates = append(candidates, arc{ci, 0, 2 * math.Pi})
+		}
+		for k, t := range ts {
+			next := ts[0] + 2*math.Pi
+			if k+1 < len(ts) {
+				next = ts[k+1]
+			}
+			if next-t > 1e-12 {
+				candidates = append(candidates, arc{ci, t, next})
+			}
+		}
+		// keep the arcs inside every other constraint
+		for _, a := range candidates {
+			mid := a.ci.at((a.t0 + a.t1) / 2)
+			keep := true
+			for j, other := range c {
+				if j != i && other.P.Dot(mid) <= other.D {
+					keep = false
+				}
+			}
+			if keep {
+				if len(ts) == 0 {
+					rings = append(rings, appendCircle(nil, a.ci, a.t0, a.t1))
+				} else {
+					arcs = append(arcs, a)
+				}
+			}
+		}
+	}
+
+	// chain arcs end to start
+	used := make([]bool, len(arcs))
+	for first := range arcs {
+		if used[first] {
+			continue
+		}
+		var ring []Vec3
+		for a := first; a != -1; {
+			used[a] = true
+			ring = appendCircle(ring, arcs[a].ci, arcs[a].t0, arcs[a].t1)
+			end := arcs[a].ci.at(arcs[a].t1)
+			a = -1
+			best := 1e-6
+			for k := range arcs {
+				if used[k] {
+					continue
+				}
+				if dist := arcs[k].ci.at(arcs[k].t0).Sub(end).Length(); dist < best {
+					a, best = k, dist
+				}
+			}
+		}
+		rings = append(rings, ring)
+	}
+	return rings
+}
+
+// appendCircle appends the arc of ci from t0 towards t1, excluding t1, in segments of at most
+// geoStep.
+func appendCircle(ring []Vec3, ci circle, t0, t1 float64) []Vec3 {
+	n := int(math.Ceil(ci.r * (t1 - t0) / geoStep))
+	if n < 1 {
+		n = 1
+	}
+	for k := 0; k < n; k++ {
+		ring = append(ring, ci.at(t0+(t1-t0)*float64(k)/float64(n)))
+	}
+	return ring
+}
+
+// unwrapRing returns a closed ring of directions in longitude and latitude, with longitude
+// continuous rather than wrapped, along with the turns it makes about the north pole. A vertex at
+// a pole becomes the segment along the pole's latitude between its neighbours' longitudes.
+func unwrapRing(ring []Vec3) (pts [][2]float64, turns int) {
+	const polar = 1 - 1e-12
+	isPole := func(v Vec3) bool { return math.Abs(v.Z/v.Length()) > polar }
+	first := -1
+	for i, v := range ring {
+		if !isPole(v) {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return nil, 0
+	}
+	wrap := func(d float64) float64 { return math.Remainder(d, 360) }
+	var x float64
+	for k := range ring {
+		v := ring[(first+k)%len(ring)]
+		if !isPole(v) {
+			lon, lat := LonLat(v)
+			if k == 0 {
+				x = lon
+			} else {
+				x += wrap(lon - x)
+			}
+			pts = append(pts, [2]float64{x, lat})
+			continue
+		}
+		// next vertex off the pole
+		var next Vec3
+		for j := 1; j <= len(ring); j++ {
+			if next = ring[(first+k+j)%len(ring)]; !isPole(next) {
+				break
+			}
+		}
+		lon, _ := LonLat(next)
+		_, lat := LonLat(v)
+		lat = math.Copysign(90, lat)
+		pts = append(pts, [2]float64{x, lat}, [2]float64{x + wrap(lon-x), lat})
+	}
+	lon, _ := LonLat(ring[first])
+	end := x + wrap(lon-x)
+	return pts, int(math.Round((end - pts[0][0]) / 360))
+}
+
+// startAntimeridian returns a ring about a pole starting where it first crosses the antimeridian,
+// so that it is not split elsewhere.
+func startAntimeridian(ring [][2]float64, turns int) [][2]float64 {
+	next := func(j int) [2]float64 {
+		if j < len(ring) {
+			return ring[j]
+		}
+		return [2]float64{ring[0][0] + 360*float64(turns), ring[0][1]}
+	}
+	for j, a := range ring {
+		b := next(j + 1)
+		lo, hi := math.Min(a[0], b[0]), math.Max(a[0], b[0])
+		x := 180 + 360*math.Ceil((lo-180)/360)
+		if x == lo && x != b[0] || x > hi {
+			continue
+		}
+		c := [2]float64{x, a[1] + (x-a[0])*(b[1]-a[1])/(b[0]-a[0])}
+		if x == b[0] {
+			c = b
+		}
+		out := [][2]float64{c}
+		for k := j + 1; k < len(ring); k++ {
+			if ring[k] != c {
+				out = append(out, ring[k])
+			}
+		}
+		for k := 0; k <= j; k++ {
+			p := ring[k]
+			if p[0] += 360 * float64(turns); p != c {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+	return ring
+}
+
+// ringArea returns the signed area of a ring in the plane, positive if counterclockwise.
+func ringArea(ring [][2]float64) float64 {
+	var a float64
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+// inRing reports whether p is inside ring in the plane.
+func inRing(p [2]float64, ring [][2]float64) bool {
+	in := false
+	for i, a := range ring {
+		b := ring[(i+1)%len(ring)]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < a[0]+(p[1]-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			in = !in
+		}
+	}
+	return in
+}
+
+// clipRing clips a ring in the plane to longitudes from lo to hi.
+func clipRing(ring [][2]float64, lo, hi float64) [][2]float64 {
+	clip := func(ring [][2]float64, x float64, keep func(float64) bool) [][2]float64 {
+		var out [][2]float64
+		for i, p := range ring {
+			q := ring[(i+1)%len(ring)]
+			if keep(p[0]) {
+				out = append(out, p)
+			}
+			if keep(p[0]) != keep(q[0]) {
+				c := [2]float64{x, p[1] + (x-p[0])*(q[1]-p[1])/(q[0]-p[0])}
+				if len(out) == 0 || out[len(out)-1] != c {
+					out = append(out, c)
+				}
+			}
+		}
+		if len(out) > 1 && out[0] == out[len(out)-1] {
+			out = out[:len(out)-1]
+		}
+		return out
+	}
+	ring = clip(ring, lo, func(x float64) bool { return x >= lo })
+	return clip(ring, hi, func(x float64) bool { return x <= hi })
+}
+
+// geoPolygons returns GeoJSON polygons, each an exterior ring followed by its holes, of the region
+// bounded by rings of directions with the region on their left. Rings about a pole are closed over
+// the pole opposite one outside the region, and all rings are split at the antimeridian. contains
+// reports whether a direction is inside the region.
+func geoPolygons(rings [][]Vec3, contains func(Vec3) bool) [][][][2]float64 {
+	var planar [][][2]float64
+	polar := len(rings) == 0
+	var turns []int
+	for _, ring := range rings {
+		pts, n := unwrapRing(ring)
+		if len(pts) == 0 {
+			continue
+		}
+		planar = append(planar, pts)
+		turns = append(turns, n)
+		polar = polar || n != 0
+	}
+	if polar {
+		// close rings about a pole over one pole while the region excludes the other, or else
+		// with the whole sphere as an additional exterior
+		pole := 90.0
+		if contains(Vec3{0, 0, -1}) {
+			pole = -90
+			if contains(Vec3{0, 0, 1}) {
+				pole = 90
+				planar = append(planar, [][2]float64{{-180, -90}, {180, -90}, {180, 90}, {-180, 90}})
+				turns = append(turns, 0)
+			}
+		}
+		for i, pts := range planar {
+			if turns[i] != 0 {
+				pts = startAntimeridian(pts, turns[i])
+				x0, lat0 := pts[0][0], pts[0][1]
+				x1 := x0 + 360*float64(turns[i])
+				planar[i] = append(pts, [2]float64{x1, lat0}, [2]float64{x1, pole}, [2]float64{x0, pole})
+			}
+		}
+	}
+
+	// split at the antimeridian
+	var exteriors, holes [][][2]float64
+	for _, pts := range planar {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, p := range pts {
+			lo, hi = math.Min(lo, p[0]), math.Max(hi, p[0])
+		}
+		shift := 360 * math.Floor((lo+180)/360)
+		for k := 0.0; 360*k-180 < hi-shift; k++ {
+			off := shift + 360*k
+			clipped := clipRing(pts, off-180, off+180)
+			for i := range clipped {
+				clipped[i][0] -= off
+			}
+			switch a := ringArea(clipped); {
+			case a > 1e-9:
+				exteriors = append(exteriors, clipped)
+			case a < -1e-9:
+				holes = append(holes, clipped)
+			}
+		}
+	}
+
+	// assign each hole to the smallest exterior containing it
+	polys := make([][][][2]float64, len(exteriors))
+	for i, ring := range exteriors {
+		polys[i] = [][][2]float64{closeRing(ring)}
+	}
+	for _, hole := range holes {
+		p := hole[0]
+		for _, q := range hole {
+			if math.Abs(q[0]) < 180 && math.Abs(q[1]) < 90 {
+				p = q
+				break
+			}
+		}
+		best, area := -1, math.Inf(1)
+		for i, ring := range exteriors {
+			if a := ringArea(ring); a < area && inRing(p, ring) {
+				best, area = i, a
+			}
+		}
+		if best != -1 {
+			polys[best] = append(polys[best], closeRing(hole))
+		}
+	}
+	return polys
+}
+
+// closeRing returns ring with its first point repeated at the end, as GeoJSON requires.
+func closeRing(ring [][2]float64) [][2]float64 {
+	return append(ring[:len(ring):len(ring)], ring[0])
+}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Features    []geoJSONObject `json:"features"`
+}
+
+// ReadGeoJSON reads the Polygon and MultiPolygon geometries of a GeoJSON geometry, Feature,
+// FeatureCollection or GeometryCollection as a Domain of spherical triangles, taking edges as great
+// circle arcs. Points and lines within are skipped, but it is an error if there are no polygons.
+// Polygons must be simple, and holes are not supported as a Domain cannot subtract them.
+func ReadGeoJSON(r io.Reader) (Domain, error) {
+	var obj geoJSONObject
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return nil, err
+	}
+	d, err := obj.domain(nil)
+	if err == nil && len(d) == 0 {
+		return nil, fmt.Errorf("GeoJSON %v has no polygons", obj.Type)
+	}
+	return d, err
+}
+
+func (obj *geoJSONObject) domain(d Domain) (Domain, error) {
+	var err error
+	switch obj.Type {
+	case "FeatureCollection":
+		for i := range obj.Features {
+			if d, err = obj.Features[i].domain(d); err != nil {
+				return nil, err
+			}
+		}
+	case "Feature":
+		if obj.Geometry != nil {
+			return obj.Geometry.domain(d)
+		}
+	case "GeometryCollection":
+		for i := range obj.Geometries {
+			if d, err = obj.Geometries[i].domain(d); err != nil {
+				return nil, err
+			}
+		}
+	case "Polygon":
+		var poly [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &poly); err != nil {
+			return nil, err
+		}
+		return polygonDomain(d, poly)
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &polys); err != nil {
+			return nil, err
+		}
+		for _, poly := range polys {
+			if d, err = polygonDomain(d, poly); err != nil {
+				return nil, err
+			}
+		}
+	case "Point", "MultiPoint", "LineString", "MultiLineString":
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q", obj.Type)
+	}
+	return d, nil
+}
+
+// polygonDomain appends the triangles of a GeoJSON polygon to d.
+func polygonDomain(d Domain, poly [][][]float64) (Domain, error) {
+	if len(poly) != 1 {
+		return nil, fmt.Errorf("polygon has %v rings, holes are not supported", len(poly))
+	}
+	coords := poly[0]
+	if len(coords) > 1 && slices.Equal(coords[0], coords[len(coords)-1]) {
+		coords = coords[:len(coords)-1]
+	}
+	if len(coords) < 3 {
+		return nil, fmt.Errorf("polygon has %v positions", len(coords))
+	}
+	ring := make(Polygon, len(coords))
+	for i, c := range coords {
+		if len(c) < 2 {
+			return nil, fmt.Errorf("position has %v coordinates", len(c))
+		}
+		ring[i] = FromLonLat(c[0], c[1])
+	}
+	// exterior rings should be counterclockwise, but older data may not be. Longitudes are
+	// unwrapped so rings crossing the antimeridian keep their winding, and rings about a pole
+	// are taken as given.
+	if planar, turns := unwrapRing(ring); turns == 0 && ringArea(planar) < 0 {
+		for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+			ring[i], ring[j] = ring[j], ring[i]
+		}
+	}
+	return triangulate(d, ring)
+}
+
+// triangulate appends the triangles of a simple spherical polygon, counterclockwise seen from
+// outside, to d by clipping ears.
+func triangulate(d Domain, ring []Vec3) (Domain, error) {
+	const eps = 1e-15
+	ring = append([]Vec3(nil), ring...)
+	triangle := func(a, b, c Vec3) *Convex {
+		cv := make(Convex, 3)
+		for k, p := range [3]Vec3{a.Cross(b), b.Cross(c), c.Cross(a)} {
+			p, _ = p.Normalized()
+			cv[k] = &Constraint{P: p, D: 0}
+		}
+		return &cv
+	}
+	for len(ring) > 3 {
+		// drop collinear and repeated positions before clipping, which would leave slivers
+		collinear := false
+		for i := range ring {
+			a, b, c := ring[(i+len(ring)-1)%len(ring)], ring[i], ring[(i+1)%len(ring)]
+			if math.Abs(a.Dot(b.Cross(c))) < eps {
+				ring = append(ring[:i], ring[i+1:]...)
+				collinear = true
+				break
+			}
+		}
+		if collinear {
+			continue
+		}
+		found := false
+		for i := range ring {
+			a, b, c := ring[(i+len(ring)-1)%len(ring)], ring[i], ring[(i+1)%len(ring)]
+			if a.Dot(b.Cross(c)) < 0 {
+				continue
+			}
+			ab, bc, ca := a.Cross(b), b.Cross(c), c.Cross(a)
+			ear := true
+			for _, p := range ring {
+				if p == a || p == b || p == c {
+					continue
+				}
+				if p.Dot(ab) >= 0 && p.Dot(bc) >= 0 && p.Dot(ca) >= 0 {
+					ear = false
+					break
+				}
+			}
+			if ear {
+				d = append(d, triangle(a, b, c))
+				ring = append(ring[:i], ring[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("polygon is not simple")
+		}
+	}
+	if a, b, c := ring[0], ring[1], ring[2]; a.Dot(b.Cross(c)) > eps {
+		d = append(d, triangle(a, b, c))
+	}
+	return d, nil
+}
@@ -1,8 +1,6 @@
 package htm
 
-import (
-	"github.com/azul3d/engine/lmath"
-)
+import "fmt"
 
 type Sign int
 
@@ -22,16 +20,16 @@ const (
 )
 
 type Tester interface {
-	Test(v0, v1, v2 lmath.Vec3) Coverage
+	Test(v0, v1, v2 Vec3) Coverage
 }
 
 // Constraint is a circular area, given by the plane slicing it off the sphere.
 type Constraint struct {
-	P lmath.Vec3
+	P Vec3
 	D float64
 }
 
-func (c *Constraint) Test(v0, v1, v2 lmath.Vec3) Coverage {
+func (c *Constraint) Test(v0, v1, v2 Vec3) Coverage {
 	a0 := c.P.Dot(v0) > c.D
 	a1 := c.P.Dot(v1) > c.D
 	a2 := c.P.Dot(v2) > c.D
@@ -49,7 +47,7 @@ func (c *Constraint) Test(v0, v1, v2 lmath.Vec3) Coverage {
 // Convex is a combination of constraints (logical AND of constraints).
 type Convex []*Constraint
 
-func (c Convex) Test(v0, v1, v2 lmath.Vec3) Coverage {
+func (c Convex) Test(v0, v1, v2 Vec3) Coverage {
 	r := Inside
 	for _, cn := range c {
 		cv := cn.Test(v0, v1, v2)
@@ -70,7 +68,7 @@ func (c Convex) Sign() Sign {
 // Domain is several convexes (logical OR of convexes).
 type Domain []*Convex
 
-func (d Domain) Test(v0, v1, v2 lmath.Vec3) Coverage {
+func (d Domain) Test(v0, v1, v2 Vec3) Coverage {
 	r := Outside
 	for _, cv := range d {
 		t := cv.Test(v0, v1, v2)
@@ -82,3 +80,15 @@ func (d Domain) Test(v0, v1, v2 lmath.Vec3) Coverage {
 	}
 	return r
 }
+
+// Polygon is a simple spherical polygon with great circle edges, its vertices counterclockwise seen
+// from outside.
+type Polygon []Vec3
+
+// Domain returns p as a Domain of spherical triangles, or an error if p is not simple.
+func (p Polygon) Domain() (Domain, error) {
+	if len(p) < 3 {
+		return nil, fmt.Errorf("polygon has %v vertices", len(p))
+	}
+	return triangulate(nil, p)
+}
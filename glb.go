@@ -0,0 +1,247 @@
+package htm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// GLBSplit selects how WriteGLB groups leaves into primitives.
+type GLBSplit int
+
+const (
+	// SplitNone writes all leaves as one primitive.
+	SplitNone GLBSplit = iota
+
+	// SplitRoots writes the leaves of each root node as a primitive.
+	SplitRoots
+
+	// SplitLevels writes the leaves of each level as a primitive.
+	SplitLevels
+)
+
+// GLBOptions select the optional attributes and grouping written by WriteGLB.
+type GLBOptions struct {
+	Normals   bool // smooth normals of Normals
+	TexCoords bool // UV coordinates of TexCoords
+	Split     GLBSplit
+}
+
+// glTF constants of the subset written.
+const (
+	glbMagic      = 0x46546c67 // "glTF"
+	glbChunkJSON  = 0x4e4f534a // "JSON"
+	glbChunkBIN   = 0x004e4942 // "BIN\x00"
+	glArrayBuffer = 34962
+	glIndexBuffer = 34963
+	glFloat       = 5126
+	glUnsignedInt = 5125
+	glTriangles   = 4
+)
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Mode       int            `json:"mode"`
+}
+
+type gltfNode struct {
+	Mesh   int            `json:"mesh"`
+	Extras map[string]any `json:"extras"`
+}
+
+// WriteGLB writes the leaves of h as a binary glTF 2.0 file of output positions, with a node and
+// mesh for each group of leaves sharing one set of vertex attributes. The extras of each node hold
+// the trixel IDs of its triangles, in order, under "trixels" so that a picked triangle maps back to
+// its node with Trixel; IDs past 2^53 lose precision in JavaScript. Nodes of SplitRoots also hold
+// the root's ID under "trixel", and those of SplitLevels the level under "level". Empty groups are
+// left out, so a mesh without leaves is written without nodes or buffers.
+func (h *HTM) WriteGLB(w io.Writer, opts GLBOptions) error {
+	// group leaves into nodes
+	type group struct {
+		leaves []int
+		extras map[string]any
+	}
+	var groups []*group
+	switch opts.Split {
+	case SplitRoots:
+		for r := 0; r < h.roots; r++ {
+			g := &group{extras: map[string]any{"trixel": h.ID(r)}}
+			for idx := range Leaves(h, r) {
+				g.leaves = append(g.leaves, idx)
+			}
+			groups = append(groups, g)
+		}
+	case SplitLevels:
+		byLevel := make(map[int]*group)
+		for idx := range h.Leaves() {
+			l := h.Trees[idx].Level
+			if byLevel[l] == nil {
+				byLevel[l] = &group{extras: map[string]any{"level": l}}
+			}
+			byLevel[l].leaves = append(byLevel[l].leaves, idx)
+		}
+		for l := 1; len(byLevel) > 0; l++ {
+			if g, ok := byLevel[l]; ok {
+				groups = append(groups, g)
+				delete(byLevel, l)
+			}
+		}
+	default:
+		g := &group{extras: map[string]any{}}
+		for idx := range h.Leaves() {
+			g.leaves = append(g.leaves, idx)
+		}
+		groups = append(groups, g)
+	}
+
+	// compact vertices used by leaves
+	vmap := make([]int, len(h.Vertices))
+	for i := range vmap {
+		vmap[i] = -1
+	}
+	var used []int
+	for idx := range h.Leaves() {
+		for _, i := range h.Trees[idx].Indices {
+			if vmap[i] == -1 {
+				vmap[i] = len(used)
+				used = append(used, i)
+			}
+		}
+	}
+
+	var bin bytes.Buffer
+	var views []gltfBufferView
+	var accessors []gltfAccessor
+	put := func(data any, target int) int {
+		off := bin.Len()
+		binary.Write(&bin, binary.LittleEndian, data)
+		views = append(views, gltfBufferView{ByteOffset: off, ByteLength: bin.Len() - off, Target: target})
+		return len(views) - 1
+	}
+
+	// glTF disallows empty accessors and buffer views, so no attributes are written without vertices
+	attributes := make(map[string]int)
+	positions := make([]float32, 0, len(used)*3)
+	lo := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	hi := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for _, i := range used {
+		p := h.PositionAt(i).Vec3f()
+		for k, x := range [3]float32{p.X, p.Y, p.Z} {
+			if x < lo[k] {
+				lo[k] = x
+			}
+			if x > hi[k] {
+				hi[k] = x
+			}
+		}
+		positions = append(positions, p.X, p.Y, p.Z)
+	}
+	if len(used) > 0 {
+		accessors = append(accessors, gltfAccessor{BufferView: put(positions, glArrayBuffer), ComponentType: glFloat, Count: len(used), Type: "VEC3", Min: lo, Max: hi})
+		attributes["POSITION"] = len(accessors) - 1
+	}
+
+	if opts.Normals && len(used) > 0 {
+		normals := h.Normals()
+		data := make([]float32, 0, len(used)*3)
+		for _, i := range used {
+			n := normals[i].Vec3f()
+			data = append(data, n.X, n.Y, n.Z)
+		}
+		accessors = append(accessors, gltfAccessor{BufferView: put(data, glArrayBuffer), ComponentType: glFloat, Count: len(used), Type: "VEC3"})
+		attributes["NORMAL"] = len(accessors) - 1
+	}
+	if opts.TexCoords && len(used) > 0 {
+		uv := TexCoords(h.Vertices)
+		data := make([]float32, 0, len(used)*2)
+		for _, i := range used {
+			data = append(data, uv[i*2], uv[i*2+1])
+		}
+		accessors = append(accessors, gltfAccessor{BufferView: put(data, glArrayBuffer), ComponentType: glFloat, Count: len(used), Type: "VEC2"})
+		attributes["TEXCOORD_0"] = len(accessors) - 1
+	}
+
+	var nodes []gltfNode
+	var meshes, scene []any
+	for _, g := range groups {
+		if len(g.leaves) == 0 {
+			continue
+		}
+		indices := make([]uint32, 0, len(g.leaves)*3)
+		ids := make([]uint64, len(g.leaves))
+		for k, idx := range g.leaves {
+			for _, i := range h.Trees[idx].Indices {
+				indices = append(indices, uint32(vmap[i]))
+			}
+			ids[k] = h.ID(idx)
+		}
+		accessors = append(accessors, gltfAccessor{BufferView: put(indices, glIndexBuffer), ComponentType: glUnsignedInt, Count: len(indices), Type: "SCALAR"})
+		prim := gltfPrimitive{Attributes: attributes, Indices: len(accessors) - 1, Mode: glTriangles}
+		meshes = append(meshes, map[string]any{"primitives": []gltfPrimitive{prim}})
+		g.extras["trixels"] = ids
+		scene = append(scene, len(nodes))
+		nodes = append(nodes, gltfNode{Mesh: len(meshes) - 1, Extras: g.extras})
+	}
+
+	// glTF disallows empty arrays, so those of an empty mesh are left out
+	doc := map[string]any{
+		"asset":  map[string]any{"version": "2.0", "generator": "dasa.cc/htm"},
+		"scene":  0,
+		"scenes": []any{map[string]any{}},
+	}
+	if len(nodes) > 0 {
+		doc["scenes"] = []any{map[string]any{"nodes": scene}}
+		doc["nodes"], doc["meshes"] = nodes, meshes
+		doc["accessors"], doc["bufferViews"] = accessors, views
+		doc["buffers"] = []any{map[string]any{"byteLength": bin.Len()}}
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+	for bin.Len()%4 != 0 {
+		bin.WriteByte(0)
+	}
+
+	le := binary.LittleEndian
+	size := 12 + 8 + len(js)
+	if bin.Len() > 0 {
+		size += 8 + bin.Len()
+	}
+	out := make([]byte, 0, size)
+	out = le.AppendUint32(out, glbMagic)
+	out = le.AppendUint32(out, 2)
+	out = le.AppendUint32(out, uint32(cap(out)))
+	out = le.AppendUint32(out, uint32(len(js)))
+	out = le.AppendUint32(out, glbChunkJSON)
+	out = append(out, js...)
+	if bin.Len() > 0 {
+		out = le.AppendUint32(out, uint32(bin.Len()))
+		out = le.AppendUint32(out, glbChunkBIN)
+		out = append(out, bin.Bytes()...)
+	}
+	_, err = w.Write(out)
+	return err
+}
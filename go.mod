@@ -2,7 +2,4 @@ module dasa.cc/htm
 
 go 1.23.1
 
-require (
-	github.com/azul3d/engine v0.0.0-20211024043305-793ea6c2839d
-	github.com/sixthgear/noise v0.0.0-20121221204059-8f186a10e306
-)
+require github.com/sixthgear/noise v0.0.0-20121221204059-8f186a10e306
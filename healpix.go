@@ -0,0 +1,312 @@
+package htm
+
+import "math"
+
+// HEALPix divides the sphere into 12 base pixels, or faces, each split into 4^order pixels. The
+// nested scheme numbers pixels so that those of order+1 within pixel p are 4p to 4p+3, as in the
+// IVOA MOC; coordinates follow the z axis as the north pole and the x axis as longitude zero.
+var (
+	healpixRing = [12]int{2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4} // ring of each face's south corner, in units of its side
+	healpixPhi  = [12]int{1, 3, 5, 7, 0, 2, 4, 6, 1, 3, 5, 7} // longitude of each face's center, in units of pi/4
+)
+
+// nestXY returns the face and coordinates within it of the nested pixel p of order.
+func nestXY(order int, p uint64) (face int, x, y uint64) {
+	face = int(p >> (2 * order))
+	for k := 0; k < order; k++ {
+		x |= (p >> (2 * k) & 1) << k
+		y |= (p >> (2*k + 1) & 1) << k
+	}
+	return face, x, y
+}
+
+// xyNest returns the nested pixel of order at coordinates x, y within face.
+func xyNest(order int, face int, x, y uint64) uint64 {
+	p := uint64(face) << (2 * order)
+	for k := 0; k < order; k++ {
+		p |= (x>>k&1)<<(2*k) | (y>>k&1)<<(2*k+1)
+	}
+	return p
+}
+
+// faceVec returns the direction at x, y within face, given as fractions of its sides.
+func faceVec(face int, x, y float64) Vec3 {
+	jr := float64(healpixRing[face]) - x - y
+	var nr, z, s float64
+	switch {
+	case jr < 1:
+		nr = jr
+		t := nr * nr / 3
+		z, s = 1-t, math.Sqrt(t*(2-t))
+	case jr > 3:
+		nr = 4 - jr
+		t := nr * nr / 3
+		z, s = t-1, math.Sqrt(t*(2-t))
+	default:
+		nr = 1
+		z = (2 - jr) * 2 / 3
+		s = math.Sqrt((1 - z) * (1 + z))
+	}
+	t := float64(healpixPhi[face])*nr + x - y
+	if t < 0 {
+		t += 8
+	} else if t >= 8 {
+		t -= 8
+	}
+	phi := 0.0
+	if nr > 1e-15 {
+		phi = math.Pi / 4 * t / nr
+	}
+	return Vec3{s * math.Cos(phi), s * math.Sin(phi), z}
+}
+
+// HEALPixCenter returns the direction of the center of the nested pixel p of order, from 0 to
+// MOCMaxOrder.
+func HEALPixCenter(order int, p uint64) Vec3 {
+	face, x, y := nestXY(order, p)
+	n := float64(uint64(1) << order)
+	return faceVec(face, (float64(x)+0.5)/n, (float64(y)+0.5)/n)
+}
+
+// HEALPixNest returns the nested pixel of order, from 0 to MOCMaxOrder, containing the direction v.
+func HEALPixNest(order int, v Vec3) uint64 {
+	v, _ = v.Normalized()
+	n := uint64(1) << order
+	tt := math.Atan2(v.Y, v.X) / (math.Pi / 2)
+	if tt < 0 {
+		tt += 4
+	}
+	if tt >= 4 {
+		tt = 0
+	}
+	za := math.Abs(v.Z)
+	if za <= 2.0/3 {
+		// equatorial region, between the lines of ascending and descending faces
+		a := float64(n) * (0.5 + tt)
+		b := float64(n) * v.Z * 0.75
+		jp, jm := uint64(a-b), uint64(a+b)
+		fp, fm := jp>>order, jm>>order
+		var face uint64
+		switch {
+		case fp == fm:
+			face = fp | 4
+		case fp < fm:
+			face = fp
+		default:
+			face = fm + 8
+		}
+		return xyNest(order, int(face), jm&(n-1), n-(jp&(n-1))-1)
+	}
+	// polar caps, from the distance to the pole
+	ntt := int(tt)
+	if ntt > 3 {
+		ntt = 3
+	}
+	tp := tt - float64(ntt)
+	s := math.Hypot(v.X, v.Y)
+	t := float64(n) * s * math.Sqrt(3/(1+za))
+	jp, jm := uint64(tp*t), uint64((1-tp)*t)
+	if jp > n-1 {
+		jp = n - 1
+	}
+	if jm > n-1 {
+		jm = n - 1
+	}
+	if v.Z >= 0 {
+		return xyNest(order, ntt, n-jm-1, n-jp-1)
+	}
+	return xyNest(order, ntt+8, jp, jm)
+}
+
+// HEALPixRing returns the ring scheme pixel of order, from 0 to MOCMaxOrder, containing the
+// direction v. Ring pixels are numbered from the north pole along rings of equal latitude,
+// eastward from longitude zero.
+func HEALPixRing(order int, v Vec3) uint64 {
+	return NestToRing(order, HEALPixNest(order, v))
+}
+
+// NestToRing returns the ring scheme index of the nested pixel p of order.
+func NestToRing(order int, p uint64) uint64 {
+	face, x, y := nestXY(order, p)
+	n := int64(1) << order
+	ncap, npix := 2*n*(n-1), 12*n*n
+	jr := int64(healpixRing[face])*n - int64(x) - int64(y) - 1
+	var nr, before, shift int64
+	switch {
+	case jr < n:
+		nr, before = jr, 2*jr*(jr-1)
+	case jr > 3*n:
+		nr = 4*n - jr
+		before = npix - 2*(nr+1)*nr
+	default:
+		nr, before, shift = n, ncap+(jr-n)*4*n, (jr-n)&1
+	}
+	jp := (int64(healpixPhi[face])*nr + int64(x) - int64(y) + 1 + shift) / 2
+	if jp > 4*n {
+		jp -= 4 * n
+	} else if jp < 1 {
+		jp += 4 * n
+	}
+	return uint64(before + jp - 1)
+}
+
+// RingToNest returns the nested index of the ring scheme pixel p of order.
+func RingToNest(order int, p uint64) uint64 {
+	n := int64(1) << order
+	ncap, npix := 2*n*(n-1), 12*n*n
+	pix := int64(p)
+	var ring, phi, nr, shift int64
+	var face int
+	switch {
+	case pix < ncap:
+		// north polar cap
+		ring = (1 + isqrt(1+2*pix)) >> 1
+		phi = pix + 1 - 2*ring*(ring-1)
+		nr = ring
+		face = int((phi - 1) / nr)
+	case pix < npix-ncap:
+		// equatorial region
+		ip := pix - ncap
+		t := ip >> (order + 2)
+		ring = t + n
+		phi = ip - t*4*n + 1
+		shift = (ring + n) & 1
+		nr = n
+		ire, irm := t+1, 2*n+1-t
+		fm := (phi - ire>>1 + n - 1) >> order
+		fp := (phi - irm>>1 + n - 1) >> order
+		switch {
+		case fp == fm:
+			face = int(fp | 4)
+		case fp < fm:
+			face = int(fp)
+		default:
+			face = int(fm + 8)
+		}
+	default:
+		// south polar cap
+		ip := npix - pix
+		ring = (1 + isqrt(2*ip-1)) >> 1
+		phi = 4*ring + 1 - (ip - 2*ring*(ring-1))
+		nr = ring
+		ring = 4*n - ring
+		face = 8 + int((phi-1)/nr)
+	}
+	rt := ring - int64(healpixRing[face])*n + 1
+	pt := 2*phi - int64(healpixPhi[face])*nr - shift - 1
+	if pt >= 2*n {
+		pt -= 8 * n
+	}
+	return xyNest(order, face, uint64((pt-rt)>>1), uint64((-pt-rt)>>1))
+}
+
+// isqrt returns the integer square root of x.
+func isqrt(x int64) int64 {
+	r := int64(math.Sqrt(float64(x)))
+	for r*r > x {
+		r--
+	}
+	for (r+1)*(r+1) <= x {
+		r++
+	}
+	return r
+}
+
+// TrixelPixels returns the nested pixels of order overlapping the node at idx. Pixels are found
+// by their bounding caps, so pixels that only nearly touch the node may be included.
+func (h *HTM) TrixelPixels(idx int, order int) []uint64 {
+	v0, v1, v2 := h.VerticesAt(idx)
+	var pixels []uint64
+	var visit func(o int, p uint64)
+	visit = func(o int, p uint64) {
+		if !pixelCap(o, p).intersects(v0, v1, v2) {
+			return
+		}
+		if o == order {
+			pixels = append(pixels, p)
+			return
+		}
+		for k := uint64(0); k < 4; k++ {
+			visit(o+1, p<<2|k)
+		}
+	}
+	for f := uint64(0); f < 12; f++ {
+		visit(0, f)
+	}
+	return pixels
+}
+
+// PixelTrixels returns the leaves of h overlapping the nested pixel p of order, found by the
+// pixel's bounding cap as with TrixelPixels.
+func (h *HTM) PixelTrixels(order int, p uint64) []int {
+	bc := pixelCap(order, p)
+	var leaves []int
+	var visit func(idx int)
+	visit = func(idx int) {
+		if !bc.intersects(h.VerticesAt(idx)) {
+			return
+		}
+		t := h.Trees[idx]
+		if t.Leaf() {
+			leaves = append(leaves, idx)
+			return
+		}
+		for _, c := range t.Children {
+			visit(c)
+		}
+	}
+	for r := 0; r < h.roots; r++ {
+		if !h.Trees[r].Empty() {
+			visit(r)
+		}
+	}
+	return leaves
+}
+
+// boundingCap is a spherical cap of center c and angular radius whose cosine and sine are given.
+type boundingCap struct {
+	c        Vec3
+	cos, sin float64
+}
+
+// pixelCap returns a cap enclosing the nested pixel p of order, padded for its curved edges.
+func pixelCap(order int, p uint64) boundingCap {
+	face, x, y := nestXY(order, p)
+	n := float64(uint64(1) << order)
+	at := func(dx, dy float64) Vec3 { return faceVec(face, (float64(x)+dx)/n, (float64(y)+dy)/n) }
+	c := at(0.5, 0.5)
+	r := 0.0
+	for _, t := range []float64{0, 0.25, 0.5, 0.75} {
+		for _, v := range [4]Vec3{at(t, 0), at(1, t), at(1-t, 1), at(0, 1-t)} {
+			r = math.Max(r, math.Atan2(c.Cross(v).Length(), c.Dot(v)))
+		}
+	}
+	r = r*1.1 + 1e-12
+	return boundingCap{c: c, cos: math.Cos(r), sin: math.Sin(r)}
+}
+
+// intersects reports whether the cap intersects the triangle v0, v1, v2 with great circle edges.
+// The cap must be less than a hemisphere.
+func (bc boundingCap) intersects(v0, v1, v2 Vec3) bool {
+	for _, v := range [3]Vec3{v0, v1, v2} {
+		if bc.c.Dot(v) >= bc.cos {
+			return true
+		}
+	}
+	if inside(v0, v1, v2, bc.c) {
+		return true
+	}
+	for _, e := range [3][2]Vec3{{v0, v1}, {v1, v2}, {v2, v0}} {
+		n, _ := e[0].Cross(e[1]).Normalized()
+		s := bc.c.Dot(n)
+		if math.Abs(s) > bc.sin {
+			continue
+		}
+		// the nearest point of the great circle is within the arc
+		q := bc.c.Sub(n.MulScalar(s))
+		if e[0].Cross(q).Dot(n) >= 0 && q.Cross(e[1]).Dot(n) >= 0 {
+			return true
+		}
+	}
+	return false
+}
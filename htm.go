@@ -2,10 +2,17 @@
 // as defined here: http://www.noao.edu/noao/staff/yao/sdss_papers/kunszt.pdf
 package htm
 
-import (
-	"fmt"
+import "fmt"
 
-	"github.com/azul3d/engine/lmath"
+// TreeFlags describe the state of a Tree.
+type TreeFlags uint8
+
+const (
+	// TreeLive marks a node in use. The zero Tree is empty.
+	TreeLive TreeFlags = 1 << iota
+
+	// TreeSplit marks a node with children.
+	TreeSplit
 )
 
 // Tree represents a node contained with an HTM.
@@ -13,16 +20,23 @@ type Tree struct {
 	Index    int
 	Level    int
 	Indices  [3]int
-	Children [4]int
-	Parent   int
+	Children [4]int // valid if Flags has TreeSplit
+	Parent   int    // -1 for root nodes
+	Flags    TreeFlags
 }
 
+// Empty returns true if the node is not in use.
 func (t Tree) Empty() bool {
-	return t.Level == 0
+	return t.Flags&TreeLive == 0
+}
+
+// Leaf returns true if the node has no children.
+func (t Tree) Leaf() bool {
+	return t.Flags&TreeSplit == 0
 }
 
 func (t Tree) Equals(x Tree) bool {
-	return t.Index == x.Index && t.Level == x.Level && t.Parent == x.Parent &&
+	return t.Index == x.Index && t.Level == x.Level && t.Parent == x.Parent && t.Flags == x.Flags &&
 		t.Indices[0] == x.Indices[0] && t.Indices[1] == x.Indices[1] && t.Indices[2] == x.Indices[2] &&
 		t.Children[0] == x.Children[0] && t.Children[1] == x.Children[1] &&
 		t.Children[2] == x.Children[2] && t.Children[3] == x.Children[3]
@@ -32,43 +46,118 @@ func (t Tree) Equals(x Tree) bool {
 type HTM struct {
 	*Edges
 
-	Vertices []lmath.Vec3
+	Vertices []Vec3
 	Trees    []Tree
+
+	// Midpoint places new vertices during subdivision. If nil, SphereMidpoint is used.
+	Midpoint MidpointFunc
+
+	// Surface maps vertices to output positions. If nil, positions are the vertices themselves.
+	Surface Surface
+
+	// OnChange, if set, is called for each triangle and vertex added or removed. See ChangeLog.
+	OnChange func(Change)
+
+	roots int
+
+	refs         []int // number of leaves referencing each vertex
+	freeTrees    []int // empty trees available for reuse
+	freeVertices []int // empty vertices available for reuse
 }
 
 // New returns an HTM with the first eight nodes that create an octahedron initialized.
 func New() *HTM {
-	h := &HTM{
-		Edges: &Edges{},
-		Vertices: []lmath.Vec3{
-			{0, 0, 1},
-			{1, 0, 0},
-			{0, 1, 0},
-			{-1, 0, 0},
-			{0, -1, 0},
-			{0, 0, -1},
-		},
-		Trees: []Tree{
-			{Index: 0, Level: 1, Indices: [3]int{1, 5, 2}}, // S0
-			{Index: 1, Level: 1, Indices: [3]int{2, 5, 3}}, // S1
-			{Index: 2, Level: 1, Indices: [3]int{3, 5, 4}}, // S2
-			{Index: 3, Level: 1, Indices: [3]int{4, 5, 1}}, // S3
-			{Index: 4, Level: 1, Indices: [3]int{1, 0, 4}}, // N0
-			{Index: 5, Level: 1, Indices: [3]int{4, 0, 3}}, // N1
-			{Index: 6, Level: 1, Indices: [3]int{3, 0, 2}}, // N2
-			{Index: 7, Level: 1, Indices: [3]int{2, 0, 1}}, // N3
-		},
-	}
-	// initialize edges for root nodes
-	for _, tr := range h.Trees {
-		i0, i1, i2 := tr.Indices[0], tr.Indices[1], tr.Indices[2]
-		h.Edges.Init(i1, i2)
-		h.Edges.Init(i0, i2)
-		h.Edges.Init(i0, i1)
+	h, err := NewMesh(octahedronVertices, octahedronFaces)
+	if err != nil {
+		panic(err)
 	}
 	return h
 }
 
+// Roots returns the number of root nodes, which occupy the first indices of Trees.
+func (h *HTM) Roots() int { return h.roots }
+
+// TreeCount returns the number of trees in use and the total including empty trees held for reuse.
+func (h *HTM) TreeCount() (live, total int) {
+	return len(h.Trees) - len(h.freeTrees), len(h.Trees)
+}
+
+// VertexCount returns the number of vertices in use and the total including empty vertices held
+// for reuse.
+func (h *HTM) VertexCount() (live, total int) {
+	return len(h.Vertices) - len(h.freeVertices), len(h.Vertices)
+}
+
+// newTree stores t in an empty tree held for reuse, or appends it, and returns its index.
+func (h *HTM) newTree(t Tree) int {
+	if n := len(h.freeTrees); n > 0 {
+		t.Index = h.freeTrees[n-1]
+		t.Flags |= TreeLive
+		h.freeTrees = h.freeTrees[:n-1]
+		h.Trees[t.Index] = t
+		return t.Index
+	}
+	t.Index = len(h.Trees)
+	t.Flags |= TreeLive
+	h.Trees = append(h.Trees, t)
+	return t.Index
+}
+
+// freeTree empties the tree at idx and holds it for reuse.
+func (h *HTM) freeTree(idx int) {
+	h.Trees[idx] = Tree{}
+	h.freeTrees = append(h.freeTrees, idx)
+}
+
+// newVertex stores v in an empty vertex held for reuse, or appends it, and returns its index.
+func (h *HTM) newVertex(v Vec3) int {
+	if n := len(h.freeVertices); n > 0 {
+		i := h.freeVertices[n-1]
+		h.freeVertices = h.freeVertices[:n-1]
+		h.Vertices[i] = v
+		h.change(VertexAdded, i)
+		return i
+	}
+	h.Vertices = append(h.Vertices, v)
+	h.change(VertexAdded, len(h.Vertices)-1)
+	return len(h.Vertices) - 1
+}
+
+// freeVertex empties the vertex at i and holds it for reuse.
+func (h *HTM) freeVertex(i int) {
+	h.Vertices[i] = Vec3{}
+	h.freeVertices = append(h.freeVertices, i)
+	h.change(VertexRemoved, i)
+}
+
+// addRefs adds n to the reference count of each vertex of t.
+func (h *HTM) addRefs(t Tree, n int) {
+	for _, i := range t.Indices {
+		for i >= len(h.refs) {
+			h.refs = append(h.refs, 0)
+		}
+		h.refs[i] += n
+	}
+}
+
+// countRefs recounts the leaves referencing each vertex.
+func (h *HTM) countRefs() {
+	h.refs = make([]int, len(h.Vertices))
+	for _, t := range h.Trees {
+		if !t.Empty() && t.Leaf() {
+			h.addRefs(t, 1)
+		}
+	}
+}
+
+// midpoint returns the vertex between v0 and v1 by the HTM's midpoint rule.
+func (h *HTM) midpoint(v0, v1 Vec3) Vec3 {
+	if h.Midpoint == nil {
+		return SphereMidpoint(v0, v1)
+	}
+	return h.Midpoint(v0, v1)
+}
+
 func (h *HTM) TreesNotEmpty() []Tree {
 	var trees []Tree
 	for _, t := range h.Trees {
@@ -83,15 +172,15 @@ func (h *HTM) TreesNotEmpty() []Tree {
 func (h *HTM) Indices() []uint32 {
 	indices := make([]uint32, 0, len(h.Trees))
 	for _, t := range h.Trees {
-		if !t.Empty() && t.Children[0] == 0 {
+		if !t.Empty() && t.Leaf() {
 			indices = append(indices, uint32(t.Indices[0]), uint32(t.Indices[1]), uint32(t.Indices[2]))
 		}
 	}
 	return indices
 }
 
-func (h *HTM) VerticesNotEmpty() []lmath.Vec3 {
-	var vertices []lmath.Vec3
+func (h *HTM) VerticesNotEmpty() []Vec3 {
+	var vertices []Vec3
 	for _, v := range h.Vertices {
 		if !(v.X == 0 && v.Y == 0 && v.Z == 0) {
 			vertices = append(vertices, v)
@@ -106,13 +195,13 @@ func (h *HTM) IndicesAt(idx int) (i0, i1, i2 int) {
 }
 
 // VerticesAt looks up a node's vertices from its indices.
-func (h *HTM) VerticesAt(idx int) (v0, v1, v2 lmath.Vec3) {
+func (h *HTM) VerticesAt(idx int) (v0, v1, v2 Vec3) {
 	i0, i1, i2 := h.IndicesAt(idx)
 	return h.Vertices[i0], h.Vertices[i1], h.Vertices[i2]
 }
 
 // VerticesFor looks up a node's vertices by the given node.
-func (h *HTM) VerticesFor(t Tree) (v0, v1, v2 lmath.Vec3) {
+func (h *HTM) VerticesFor(t Tree) (v0, v1, v2 Vec3) {
 	return h.Vertices[t.Indices[0]], h.Vertices[t.Indices[1]], h.Vertices[t.Indices[2]]
 }
 
@@ -121,7 +210,7 @@ func (h *HTM) LevelAt(idx int) int { return h.Trees[idx].Level }
 
 // EmptyAt identifies if the node at the given index has children. TODO(d) better name
 func (h *HTM) EmptyAt(idx int) bool {
-	return h.Trees[idx].Children[0] == 0 || h.Trees[h.Trees[idx].Children[0]].Empty()
+	return h.Trees[idx].Leaf()
 }
 
 // ChildrenAt returns a node's children at the given index, but does not account for it that node is empty. TODO(d) better name
@@ -139,31 +228,21 @@ func (h *HTM) TexCoordsPlanar() []float32 {
 	return TexCoordsPlanar(h.VerticesNotEmpty())
 }
 
-// SubDivide starts a recursive subdivision along all eight root nodes.
+// SubDivide starts a recursive subdivision along all root nodes.
 func (h *HTM) SubDivide(level int) {
-	SubDivide(h, 0, level)
-	SubDivide(h, 1, level)
-	SubDivide(h, 2, level)
-	SubDivide(h, 3, level)
-	SubDivide(h, 4, level)
-	SubDivide(h, 5, level)
-	SubDivide(h, 6, level)
-	SubDivide(h, 7, level)
+	for idx := 0; idx < h.roots; idx++ {
+		SubDivide(h, idx, level)
+	}
 }
 
 // LookupByCart looks up which triangle a given object belongs to by it's given cartesian coordinates.
-func (h *HTM) LookupByCart(v lmath.Vec3) (Tree, error) {
+func (h *HTM) LookupByCart(v Vec3) (Tree, error) {
 	i := -1
 
 	// Only one of these will recurse within first call.
-	LookupByCart(h, 0, v, &i)
-	LookupByCart(h, 1, v, &i)
-	LookupByCart(h, 2, v, &i)
-	LookupByCart(h, 3, v, &i)
-	LookupByCart(h, 4, v, &i)
-	LookupByCart(h, 5, v, &i)
-	LookupByCart(h, 6, v, &i)
-	LookupByCart(h, 7, v, &i)
+	for idx := 0; idx < h.roots; idx++ {
+		LookupByCart(h, idx, v, &i)
+	}
 
 	if i != -1 {
 		return h.Trees[i], nil
@@ -176,25 +255,88 @@ func (h *HTM) LookupByCart(v lmath.Vec3) (Tree, error) {
 // node fully matches, the parents children will not be returned in the results.
 func (h *HTM) Intersections(t Tester) []int {
 	var mt []int
-	Intersections(h, 0, t, &mt)
-	Intersections(h, 1, t, &mt)
-	Intersections(h, 2, t, &mt)
-	Intersections(h, 3, t, &mt)
-	Intersections(h, 4, t, &mt)
-	Intersections(h, 5, t, &mt)
-	Intersections(h, 6, t, &mt)
-	Intersections(h, 7, t, &mt)
+	for idx := 0; idx < h.roots; idx++ {
+		Intersections(h, idx, t, &mt)
+	}
 	return mt
 }
 
-func (h *HTM) Compact() {
-	h.Vertices = h.VerticesNotEmpty()
-	h.Trees = h.TreesNotEmpty()
+// Compact removes empty vertices and trees, rewriting every reference to them in Trees and Edges.
+// The returned slices map old vertex and tree indices to new ones, or -1 if removed, so that
+// external data such as GPU buffers may be updated to match.
+func (h *HTM) Compact() (vertices, trees []int) {
+	vertices = make([]int, len(h.Vertices))
+	n := 0
+	for i, v := range h.Vertices {
+		if v.X == 0 && v.Y == 0 && v.Z == 0 {
+			vertices[i] = -1
+			continue
+		}
+		vertices[i] = n
+		h.Vertices[n] = v
+		n++
+	}
+	h.Vertices = h.Vertices[:n]
+
+	trees = make([]int, len(h.Trees))
+	n = 0
+	for i, t := range h.Trees {
+		if t.Empty() {
+			trees[i] = -1
+			continue
+		}
+		trees[i] = n
+		n++
+	}
+	for i, t := range h.Trees {
+		if trees[i] == -1 {
+			continue
+		}
+		t.Index = trees[i]
+		if t.Parent != -1 {
+			t.Parent = trees[t.Parent]
+		}
+		for k, x := range t.Indices {
+			t.Indices[k] = vertices[x]
+		}
+		if !t.Leaf() {
+			for k, x := range t.Children {
+				t.Children[k] = trees[x]
+			}
+		}
+		h.Trees[t.Index] = t
+	}
+	h.Trees = h.Trees[:n]
+
+	// vertices retain their order so edges are reinitialized in their original order
+	ed := &Edges{}
+	for _, e := range h.Edges.nonempty() {
+		start, end := vertices[e.Start], vertices[e.End]
+		if start == -1 || end == -1 {
+			continue
+		}
+		x, _ := ed.edge(start, end)
+		if e.Mid != -1 && vertices[e.Mid] != -1 {
+			x.Mid = vertices[e.Mid]
+		}
+	}
+	h.Edges = ed
+
+	refs := h.refs
+	h.refs = make([]int, len(h.Vertices))
+	for i, n := range refs {
+		if i < len(vertices) && vertices[i] != -1 {
+			h.refs[vertices[i]] = n
+		}
+	}
+	h.freeTrees, h.freeVertices = nil, nil
+
+	return vertices, trees
 }
 
 func (h *HTM) CullToLevel(lvl int) {
 	for i, t := range h.Trees {
-		if t.Level == lvl && t.Children[0] != 0 {
+		if t.Level == lvl && !t.Leaf() {
 			Cull(h, i)
 		}
 	}
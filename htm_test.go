@@ -1,17 +1,28 @@
 package htm
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 	"math"
+	"math/bits"
+	"math/rand"
+	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
-
-	"github.com/azul3d/engine/lmath"
+	"unsafe"
 
 	"github.com/sixthgear/noise"
 )
@@ -35,7 +46,7 @@ func saveImage(t *testing.T, h *HTM, name string) {
 	}
 }
 
-func compareVec3s(a, b []lmath.Vec3) error {
+func compareVec3s(a, b []Vec3) error {
 	if len(a) != len(b) {
 		return fmt.Errorf("lengths don't match %v != %v\n", len(a), len(b))
 	}
@@ -102,13 +113,13 @@ func compareUint32s(a, b []uint32) error {
 func compareHTMs(a, b *HTM) error {
 	if err := compareEdges(a.Edges.nonempty(), b.Edges.nonempty()); err != nil {
 		fmt.Println("### A ###")
-		for i, edge := range a.Edges.slice {
+		for i, edge := range a.Edges.nonempty() {
 			if !edge.Empty() {
 				fmt.Printf("%v: %+v\n", i, edge)
 			}
 		}
 		fmt.Println("### B ###")
-		for i, edge := range b.Edges.slice {
+		for i, edge := range b.Edges.nonempty() {
 			if !edge.Empty() {
 				fmt.Printf("%v: %+v\n", i, edge)
 			}
@@ -120,13 +131,13 @@ func compareHTMs(a, b *HTM) error {
 	if err := compareVec3s(a.VerticesNotEmpty(), b.VerticesNotEmpty()); err != nil {
 		fmt.Println("### A ###")
 		for i, vec := range a.Vertices {
-			if !vec.Equals(lmath.Vec3Zero) {
+			if !vec.Equals(Vec3Zero) {
 				fmt.Printf("%v: %+v\n", i, vec)
 			}
 		}
 		fmt.Println("### B ###")
 		for i, vec := range b.Vertices {
-			if !vec.Equals(lmath.Vec3Zero) {
+			if !vec.Equals(Vec3Zero) {
 				fmt.Printf("%v: %+v\n", i, vec)
 			}
 		}
@@ -148,12 +159,14 @@ func compareHTMs(a, b *HTM) error {
 func validateHTM(h *HTM) error {
 	var errs []string
 
+	edges := h.Edges.nonempty()
+
 	// no duplicate edges
-	for i0, e0 := range h.Edges.slice {
+	for i0, e0 := range edges {
 		if e0.Empty() {
 			continue
 		}
-		for i1, e1 := range h.Edges.slice {
+		for i1, e1 := range edges {
 			if e1.Empty() {
 				continue
 			}
@@ -165,11 +178,11 @@ func validateHTM(h *HTM) error {
 
 	// no duplicate vertices
 	for i0, v0 := range h.Vertices {
-		if v0.Equals(lmath.Vec3Zero) {
+		if v0.Equals(Vec3Zero) {
 			continue
 		}
 		for i1, v1 := range h.Vertices {
-			if v1.Equals(lmath.Vec3Zero) {
+			if v1.Equals(Vec3Zero) {
 				continue
 			}
 			if i0 != i1 && v0.Equals(v1) {
@@ -184,7 +197,7 @@ func validateHTM(h *HTM) error {
 		indices[int(index)] = false
 	}
 	for i, v := range h.Vertices {
-		if v.Equals(lmath.Vec3Zero) {
+		if v.Equals(Vec3Zero) {
 			continue
 		}
 		if _, ok := indices[i]; !ok {
@@ -211,7 +224,7 @@ func validateHTM(h *HTM) error {
 	for _, index := range h.Indices() {
 		indices[int(index)] = false
 	}
-	for i, e := range h.Edges.slice {
+	for i, e := range edges {
 		if e.Empty() {
 			continue
 		}
@@ -225,6 +238,9 @@ func validateHTM(h *HTM) error {
 		} else {
 			indices[e.End] = true
 		}
+		if e.Mid == -1 {
+			continue
+		}
 		if _, ok := indices[e.Mid]; !ok {
 			errs = append(errs, fmt.Sprintf("edge.Mid for %+v not accounted for in indices at %v", e, i))
 		} else {
@@ -251,7 +267,7 @@ func TestConstraintSubDivisionCullSubDivision(t *testing.T) {
 	h0.SubDivide(l0)
 	h1.SubDivide(l0)
 
-	vec := lmath.Vec3{0.001, 0.001, 0.999}
+	vec := Vec3{0.001, 0.001, 0.999}
 	tr, err := h1.LookupByCart(vec)
 	if err != nil {
 		t.Fatal(err)
@@ -280,7 +296,7 @@ func TestRepeatedCull(t *testing.T) {
 	h0.SubDivide(lfrom)
 	h1.SubDivide(lfrom)
 
-	tr, err := h1.LookupByCart(lmath.Vec3{0.001, 0.999, 0.001})
+	tr, err := h1.LookupByCart(Vec3{0.001, 0.999, 0.001})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -305,7 +321,7 @@ func TestConstraintSubDivision(t *testing.T) {
 	defer saveImage(t, h, "test.constraint.subdivision.png")
 	h.SubDivide(l0)
 
-	cn := &Constraint{lmath.Vec3{0.001, 0.001, 0.999}, 0.85}
+	cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
 	for idx := range h.Intersections(cn) {
 		SubDivide(h, idx, l1)
 	}
@@ -315,6 +331,78 @@ func TestConstraintSubDivision(t *testing.T) {
 	}
 }
 
+func TestCompact(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+
+	a, err := h.LookupByCart(Vec3{0.001, 0.001, 0.999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := h.LookupByCart(Vec3{0.999, 0.001, 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, a.Index, 5)
+	SubDivide(h, b.Index, 5)
+	// leave holes before the trees and vertices of b
+	Cull(h, a.Index)
+
+	before := make(map[int][3]Vec3)
+	for i, tr := range h.Trees {
+		if !tr.Empty() {
+			v0, v1, v2 := h.VerticesAt(i)
+			before[i] = [3]Vec3{v0, v1, v2}
+		}
+	}
+	nv := len(h.VerticesNotEmpty())
+
+	vmap, tmap := h.Compact()
+	if len(h.Vertices) != nv || len(h.Trees) != len(before) {
+		t.Fatalf("expected %v vertices and %v trees but have %v and %v", nv, len(before), len(h.Vertices), len(h.Trees))
+	}
+	for i, vs := range before {
+		if tmap[i] == -1 {
+			t.Fatalf("tree %v removed", i)
+		}
+		v0, v1, v2 := h.VerticesAt(tmap[i])
+		if !v0.Equals(vs[0]) || !v1.Equals(vs[1]) || !v2.Equals(vs[2]) {
+			t.Fatalf("tree %v remapped to %v with different vertices", i, tmap[i])
+		}
+	}
+	for i, j := range vmap {
+		if j != -1 && j >= len(h.Vertices) {
+			t.Fatalf("vertex %v remapped out of range to %v", i, j)
+		}
+	}
+	for i, tr := range h.Trees {
+		if tr.Index != i {
+			t.Fatalf("tree at %v has index %v", i, tr.Index)
+		}
+		if !tr.Leaf() {
+			for _, c := range tr.Children {
+				if h.Trees[c].Parent != i || h.Trees[c].Level != tr.Level+1 {
+					t.Fatalf("child %+v does not belong to %+v", h.Trees[c], tr)
+				}
+			}
+		}
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+
+	// the compacted mesh continues to subdivide and cull
+	tr, err := h.LookupByCart(Vec3{0.999, 0.001, 0.001})
+	if err != nil || tr.Level != 5 {
+		t.Fatalf("lookup failed after compact: %+v %v", tr, err)
+	}
+	Cull(h, tmap[b.Index])
+	SubDivide(h, tmap[a.Index], 4)
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestConvexCull(t *testing.T) {
 	l0, l1 := 3, 5
 	h := New()
@@ -322,8 +410,8 @@ func TestConvexCull(t *testing.T) {
 	h.SubDivide(l0)
 
 	d := 0.85
-	pos := lmath.Vec3{0.001, 0.001, 0.999}
-	lastPos := lmath.Vec3{0.001, 0.444, 0.999}
+	pos := Vec3{0.001, 0.001, 0.999}
+	lastPos := Vec3{0.001, 0.444, 0.999}
 
 	cn0 := &Constraint{pos, d}
 	cn1 := &Constraint{pos.MulScalar(-1), -d}
@@ -348,7 +436,7 @@ func TestConstraintCull(t *testing.T) {
 	h0.SubDivide(l0)
 	h1.SubDivide(l0)
 
-	cn := &Constraint{lmath.Vec3{0.001, 0.001, 0.999}, 0.85}
+	cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
 	for idx := range h1.Intersections(cn) {
 		SubDivide(h1, idx, l1)
 	}
@@ -376,11 +464,11 @@ func TestMotionCull(t *testing.T) {
 
 	// simulate motion
 	d := 0.99
-	pos := lmath.Vec3{0.001, 0.001, 0.999}
-	lastPos := lmath.Vec3{0, 0, 0}
+	pos := Vec3{0.001, 0.001, 0.999}
+	lastPos := Vec3{0, 0, 0}
 
 	for pos.Y = 0.001; pos.Y < 0.644; pos.Y += 0.001 {
-		if !lastPos.Equals(lmath.Vec3Zero) {
+		if !lastPos.Equals(Vec3Zero) {
 			cn0 := &Constraint{pos, d}
 
 			cn1 := &Constraint{pos.MulScalar(-1), -d}
@@ -402,6 +490,240 @@ func TestMotionCull(t *testing.T) {
 	}
 }
 
+func TestLOD(t *testing.T) {
+	h := New()
+	defer saveImage(t, h, "test.lod.png")
+
+	lod := NewLOD(h, 2, 6, 0.1)
+	lod.MaxTriangles = 4000
+
+	cam := Vec3{0, 0, 1.5}
+	for i := 0; i < 40; i++ {
+		a := float64(i) * 0.02
+		cam = Vec3{1.5 * math.Sin(a), 0.01, 1.5 * math.Cos(a)}
+		st := lod.Update(cam)
+		if st.Triangles > lod.MaxTriangles {
+			t.Fatalf("frame %v exceeded triangle budget: %v", i, st.Triangles)
+		}
+	}
+	// let the mesh settle at the final camera position
+	for i := 0; i < 8; i++ {
+		lod.Update(cam)
+	}
+
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := cam.Normalized()
+	near, err := h.LookupByCart(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if near.Level != lod.MaxLevel {
+		t.Fatalf("expected level %v below camera but have %v", lod.MaxLevel, near.Level)
+	}
+	far, err := h.LookupByCart(dir.MulScalar(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if far.Level != lod.MinLevel {
+		t.Fatalf("expected level %v behind camera but have %v", lod.MinLevel, far.Level)
+	}
+}
+
+func TestLODReuse(t *testing.T) {
+	h := New()
+	h.SubDivide(2)
+	dir, _ := Vec3{0.3, 0.2, 0.9}.Normalized()
+	tr, err := h.LookupByCart(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, tr.Index, 4)
+	// a spike at the midpoints of a level 3 node gives its children more error than it has, so
+	// they are split candidates while it merges and frees them
+	parent := h.Trees[tr.Index].Children[0]
+	mid := h.Trees[h.Trees[parent].Children[3]].Indices
+	h.Surface = Displaced{Height: func(v Vec3) float64 {
+		for _, i := range mid {
+			if v.Dot(h.Vertices[i]) > 0.9999 {
+				return 1
+			}
+		}
+		return 0
+	}}
+	cam := h.Vertices[mid[0]].MulScalar(10)
+
+	// leaves below the minimum split first, reusing the slots freed by merges
+	lod := NewLOD(h, 3, 6, 0.06)
+	st := lod.Update(cam)
+	if st.Merges == 0 || st.Splits == 0 {
+		t.Fatalf("expected merges and splits but have %+v", st)
+	}
+	for idx := range h.Leaves() {
+		if l := h.Trees[idx].Level; l != 3 {
+			t.Fatalf("leaf %v at level %v changed by more than one level", idx, l)
+		}
+	}
+	if n := len(h.Indices()) / 3; n != st.Triangles {
+		t.Fatalf("reported %v triangles but have %v", st.Triangles, n)
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefine(t *testing.T) {
+	h := New()
+	defer saveImage(t, h, "test.refine.png")
+
+	target := Vec3{0.001, 0.001, 0.999}
+	p := func(h *HTM, idx int) float64 {
+		v0, v1, v2 := h.VerticesAt(idx)
+		c, _ := v0.Add(v1).Add(v2).Normalized()
+		return (c.Dot(target) + 1) * v0.Sub(v1).Length()
+	}
+
+	st := Refine(h, 1000, 0, p)
+	if st.Triangles > 1000 || st.Triangles < 997 {
+		t.Fatalf("expected budget of 1000 triangles to be filled, have %v", st.Triangles)
+	}
+	if n := len(h.Indices()) / 3; n != st.Triangles {
+		t.Fatalf("reported %v triangles but have %v", st.Triangles, n)
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+	near, _ := h.LookupByCart(target)
+	far, _ := h.LookupByCart(target.MulScalar(-1))
+	if near.Level <= far.Level {
+		t.Fatalf("expected deeper level near target, have %v near and %v far", near.Level, far.Level)
+	}
+
+	// shrinking the budget merges low priority areas back
+	st = Refine(h, 200, 0, p)
+	if st.Triangles > 200 || st.Merges == 0 {
+		t.Fatalf("expected merges down to 200 triangles, have %+v", st)
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+
+	// moving the target trades detail from the old area to the new one
+	target = Vec3{0.999, 0.001, 0.001}
+	for i := 0; i < 4; i++ {
+		st = Refine(h, 200, 150, p)
+		if st.Triangles > 200 || st.Vertices > 150 {
+			t.Fatalf("budget exceeded: %+v", st)
+		}
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+	near, _ = h.LookupByCart(target)
+	far, _ = h.LookupByCart(Vec3{0.001, 0.001, 0.999})
+	if near.Level <= far.Level {
+		t.Fatalf("expected deeper level near new target, have %v near and %v far", near.Level, far.Level)
+	}
+}
+
+func TestRefineReuse(t *testing.T) {
+	h := New()
+	h.SubDivide(2)
+	a, err := h.LookupByCart(Vec3{0.3, 0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := h.LookupByCart(Vec3{-0.3, -0.2, -0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, a.Index, 3)
+
+	// splitting l makes room by merging a, whose freed children's slots are reused by the
+	// children of l which must not be split at the old priority of a's children
+	p := func(h *HTM, idx int) float64 {
+		switch t := h.Trees[idx]; {
+		case idx == a.Index:
+			return 0
+		case t.Parent == a.Index:
+			return 5
+		case idx == l.Index:
+			return 10
+		case t.Parent == l.Index:
+			return -1
+		}
+		return 1
+	}
+	st := Refine(h, len(h.Indices())/3, 0, p)
+	if st.Splits != 1 || st.Merges != 1 {
+		t.Fatalf("expected a split and a merge but have %+v", st)
+	}
+	for idx := range h.Leaves() {
+		if lvl := h.Trees[idx].Level; lvl > 3 {
+			t.Fatalf("leaf %v at level %v split at a stale priority", idx, lvl)
+		}
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMotionSteadyState(t *testing.T) {
+	l0, l1 := 3, 6
+	h := New()
+	h.SubDivide(l0)
+
+	d := 0.99
+	move := func(from, to float64) {
+		pos := Vec3{0.001, from, 0.999}
+		lastPos := pos
+		step := 0.002
+		if to < from {
+			step = -step
+		}
+		for ; (step > 0 && pos.Y < to) || (step < 0 && pos.Y > to); pos.Y += step {
+			cn0 := &Constraint{pos, d}
+			cn1 := &Constraint{pos.MulScalar(-1), -d}
+			cn2 := &Constraint{lastPos.MulScalar(-1), -d}
+			for _, idx := range h.Intersections(cn0) {
+				SubDivide(h, idx, l1)
+			}
+			for _, idx := range h.Intersections(Convex{cn1, cn2}) {
+				CullToLevel(h, idx, l0)
+			}
+			lastPos = pos
+		}
+	}
+
+	move(0.001, 0.6)
+	_, trees := h.TreeCount()
+	_, vertices := h.VertexCount()
+	for i := 0; i < 3; i++ {
+		move(0.6, 0.001)
+		move(0.001, 0.6)
+	}
+	if _, n := h.TreeCount(); n != trees {
+		t.Fatalf("expected constant %v trees but have %v", trees, n)
+	}
+	if _, n := h.VertexCount(); n != vertices {
+		t.Fatalf("expected constant %v vertices but have %v", vertices, n)
+	}
+
+	live, _ := h.TreeCount()
+	if n := len(h.TreesNotEmpty()); live != n {
+		t.Fatalf("expected %v live trees but have %v", n, live)
+	}
+	live, _ = h.VertexCount()
+	if n := len(h.VerticesNotEmpty()); live != n {
+		t.Fatalf("expected %v live vertices but have %v", n, live)
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestImageL9Intersect(t *testing.T) {
 	h := New()
 	h.SubDivide(9)
@@ -416,7 +738,7 @@ func TestImageL9Intersect(t *testing.T) {
 		m.Set(x, y, color.RGBA{uint8(z * 255), 0, 0, 255})
 	})
 
-	cn := &Constraint{lmath.Vec3{0, 0, 1}, 0.75}
+	cn := &Constraint{Vec3{0, 0, 1}, 0.75}
 	m2 := ImageConstraint(h, cn, size, func(m *image.RGBA, x, y int, z float64) {
 		m.Set(x, y, color.RGBA{0, uint8(z * 255), 0, 0})
 	})
@@ -442,8 +764,8 @@ func TestImageL7SubdivideL9Intersect(t *testing.T) {
 		m.Set(x, y, color.RGBA{uint8(z * 255), 0, 0, 255})
 	})
 
-	cn0 := &Constraint{lmath.Vec3{0, 1, 0}, -0.01}
-	cn1 := &Constraint{lmath.Vec3{0, -1, 0}, -0.01}
+	cn0 := &Constraint{Vec3{0, 1, 0}, -0.01}
+	cn1 := &Constraint{Vec3{0, -1, 0}, -0.01}
 	cv := Convex{cn0, cn1}
 	for _, idx := range h.Intersections(cv) {
 		SubDivide(h, idx, 9)
@@ -483,7 +805,7 @@ func TestImageL9Noise(t *testing.T) {
 		m.Set(x, y, color.RGBA{uint8(z * 255), 0, 0, 255})
 	})
 
-	cn := &Constraint{lmath.Vec3{0, 0, 1}, 0.5}
+	cn := &Constraint{Vec3{0, 0, 1}, 0.5}
 	m2 := ImageConstraint(h, cn, size, func(m *image.RGBA, x, y int, z float64) {
 		m.Set(x, y, color.RGBA{25, uint8(z * 110), 0, 40})
 	})
@@ -495,125 +817,1936 @@ func TestImageL9Noise(t *testing.T) {
 	}
 }
 
-func testL11Info(t *testing.T) {
-	h := New()
-	h.SubDivide(11)
-	t.Logf("\n\n   Trees: %v\nVertices:  %v\n Indices: %v\n   Edges: %v\n\n", len(h.Trees), len(h.Vertices), len(h.Indices()), len(h.Edges.slice))
-}
-
-func TestNew(t *testing.T) {
+func TestRasterize(t *testing.T) {
 	h := New()
-	if len(h.Trees) != 8 {
-		t.Fatal("Trees not initialized correctly.")
+	h.SubDivide(4)
+	size := image.Pt(320, 320)
+	front := color.RGBA{0, 0, 255, 255}
+	back := color.RGBA{255, 0, 0, 255}
+
+	// every visible pixel should be of a triangle facing the viewer
+	m := Rasterize(h, size, RasterOptions{Fill: func(tr Tree, c Coverage) color.Color {
+		v0, v1, v2 := h.VerticesAt(tr.Index)
+		if v0.Add(v1).Add(v2).Z > 0 {
+			return front
+		}
+		return back
+	}})
+	filled := 0
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			switch m.RGBAAt(x, y) {
+			case front:
+				filled++
+			case back:
+				t.Fatalf("back triangle visible at %v, %v", x, y)
+			}
+		}
 	}
-	if len(h.Vertices) == 0 {
-		t.Fatal("HTM vertices not initialized.")
+	// the sphere projects to a disc
+	if want := math.Pi / 4 * float64(size.X*size.Y); math.Abs(float64(filled)-want) > 0.02*want {
+		t.Fatalf("expected about %v filled pixels but have %v", want, filled)
+	}
+	if c := m.RGBAAt(0, 0); c != (color.RGBA{}) {
+		t.Fatalf("expected empty corner but have %v", c)
 	}
-}
-
-type set struct {
-	Data []float64
-}
 
-func (s *set) put(x float64) {
-	x = math.Abs(x)
-	for _, v := range s.Data {
-		if lmath.Equal(v, x) {
-			return
+	cn := &Constraint{Vec3{0, 0, 1}, 0.75}
+	white := color.RGBA{255, 255, 255, 255}
+	m = Rasterize(h, size, RasterOptions{
+		Tester: cn,
+		Fill: func(tr Tree, c Coverage) color.Color {
+			if c == Inside {
+				return color.RGBA{0, 255, 0, 255}
+			}
+			return color.RGBA{255, 255, 0, 255}
+		},
+		Edge:  func(Tree, Coverage) color.Color { return white },
+		Shade: true,
+	})
+	edges, inside := 0, 0
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := m.RGBAAt(x, y)
+			switch {
+			case c == white:
+				edges++
+			case c.R == 0 && c.G > 200:
+				// shaded green of leaves facing the viewer
+				inside++
+			}
+			if p := (Vec3{float64(x) + 0.5, float64(y) + 0.5, 0}).Sub(Vec3{160, 160, 0}); p.Length() > 140 && c != (color.RGBA{}) {
+				t.Fatalf("pixel %v, %v outside the constraint drawn as %v", x, y, c)
+			}
 		}
 	}
-	s.Data = append(s.Data, x)
-}
-
-func testUnique(t *testing.T) {
-	h := New()
-	h.SubDivide(8)
-	s := &set{}
-	for _, v := range h.Vertices {
-		s.put(v.X)
-		s.put(v.Y)
-		s.put(v.Z)
+	if edges == 0 {
+		t.Fatal("no wireframe edges drawn")
+	}
+	if inside == 0 {
+		t.Fatal("no leaves inside the constraint drawn")
+	}
+	if err := WriteImage("samples/test.rasterize.png", m); err != nil {
+		t.Fatal(err)
 	}
-	sort.Float64s(s.Data)
-	// t.Log("Unique")
-	// for _, v := range s.Data {
-	// 	t.Logf("%v", v)
-	// }
-	t.Logf("Unique: %v\n", len(s.Data))
 }
 
-func TestSubDivide2(t *testing.T) {
+func TestSurface(t *testing.T) {
 	h := New()
-	h.SubDivide(2)
-	if len(h.Vertices) != 18 {
-		t.Fatalf("Expected 18 vertices but got %v.", len(h.Vertices))
+	h.SubDivide(4)
+	vertices := append([]Vec3(nil), h.Vertices...)
+
+	h.Surface = WGS84
+	p := h.Positions()
+	if !equal(p[0].Z, WGS84.Polar) || !equal(p[1].X, WGS84.Equatorial) {
+		t.Fatalf("expected poles and equator on the ellipsoid, have %v and %v", p[0], p[1])
+	}
+	for i, v := range p {
+		// x²/a² + y²/a² + z²/b² = 1
+		a, b := WGS84.Equatorial, WGS84.Polar
+		if d := (v.X*v.X+v.Y*v.Y)/(a*a) + v.Z*v.Z/(b*b); math.Abs(d-1) > 1e-9 {
+			t.Fatalf("position %v at %v not on ellipsoid: %v", v, i, d)
+		}
 	}
 
-	cmp := func(a float64, b string) bool { return fmt.Sprintf("%.3f", a) == b }
-	check := func(msg string, expects [3]string, v lmath.Vec3) {
-		if !cmp(v.X, expects[0]) {
-			t.Fatal(msg, "failed for x, expected", expects[0], "but have", v.X)
+	h.Surface = Displaced{Sphere{2}, func(v Vec3) float64 {
+		return noise.OctaveNoise3d(v.X, v.Y, v.Z, 5, 0.8, 1.3)
+	}}
+	h.SubDivide(5)
+	for i, v := range vertices {
+		if !h.Vertices[i].Equals(v) {
+			t.Fatalf("vertex %v changed to %v", v, h.Vertices[i])
 		}
-		if !cmp(v.Y, expects[1]) {
-			t.Fatal(msg, "failed for y, expected", expects[1], "but have", v.Y)
+	}
+	for i, v := range h.Vertices {
+		if !equal(v.Length(), 1) {
+			t.Fatalf("vertex %v at %v is not a unit direction", v, i)
 		}
-		if !cmp(v.Z, expects[2]) {
-			t.Fatal(msg, "failed for z, expected", expects[2], "but have", v.Z)
+		want := 2 + noise.OctaveNoise3d(v.X, v.Y, v.Z, 5, 0.8, 1.3)
+		if !equal(h.PositionAt(i).Length(), want) {
+			t.Fatalf("expected displaced length %v but have %v", want, h.PositionAt(i).Length())
 		}
 	}
-
-	check("first subdivision", [3]string{"0.000", "0.707", "-0.707"}, h.Vertices[6])
-	check("second subdivision", [3]string{"0.707", "0.707", "0.000"}, h.Vertices[7])
-	check("third subdivision", [3]string{"0.707", "0.000", "-0.707"}, h.Vertices[8])
-
+	if tr, err := h.LookupByCart(Vec3{0.9, 0.1, 0.1}); err != nil || tr.Level != 5 {
+		t.Fatalf("lookup failed on displaced mesh: %v %v", tr, err)
+	}
 	if err := validateHTM(h); err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestTexCoords(t *testing.T) {
-	h := New()
-	h.SubDivide(2)
-	tc := h.TexCoords()
-	if (len(tc) % 2) != 0 {
-		t.Fatal("Uneven UV mapping.")
+func TestImageHeight(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 4, 2))
+	m.SetGray(0, 0, color.Gray{255}) // north-west quadrant is white
+	height := ImageHeight(m, 10)
+	if x := height(Vec3{-0.6, -0.1, 0.8}); !equal(x, 10) {
+		t.Fatalf("expected height 10 but have %v", x)
+	}
+	if x := height(Vec3{0.6, 0.1, -0.8}); x != 0 {
+		t.Fatalf("expected height 0 but have %v", x)
 	}
 }
 
-func TestLookupByCart(t *testing.T) {
+func TestNormals(t *testing.T) {
 	h := New()
-	h.SubDivide(7)
-	_, err := h.LookupByCart(lmath.Vec3{0.9, 0.1, 0.1})
-	if err != nil {
-		t.Fatal(err)
+	h.SubDivide(5)
+
+	for i, n := range h.Normals() {
+		if d := n.Dot(h.Vertices[i]); d < 0.99 {
+			t.Fatalf("normal %v at %v deviates from sphere: %v", n, i, d)
+		}
+	}
+	if n, m := len(h.FaceNormals()), len(h.Indices())/3; n != m {
+		t.Fatalf("expected %v face normals but have %v", m, n)
+	}
+	positions, normals, tc := h.Flat()
+	if len(positions) != len(h.Indices()) || len(normals) != len(positions) || len(tc) != len(positions)*2 {
+		t.Fatalf("flat lengths don't match: %v %v %v", len(positions), len(normals), len(tc))
 	}
-}
 
-func BenchmarkL5(b *testing.B) {
-	for n := 0; n < b.N; n++ {
-		h := New()
-		h.SubDivide(5)
+	tangents, bitangents := h.Tangents()
+	// u increases eastward and v southward from the equator at +X
+	if d := tangents[1].Dot(Vec3{0, 1, 0}); d < 0.99 {
+		t.Fatalf("expected east tangent but have %v", tangents[1])
+	}
+	if d := bitangents[1].Dot(Vec3{0, 0, -1}); d < 0.99 {
+		t.Fatalf("expected south bitangent but have %v", bitangents[1])
+	}
+	for i, n := range h.Normals() {
+		if !equal(tangents[i].Dot(n), 0) || !equal(bitangents[i].Dot(n), 0) || !equal(tangents[i].Length(), 1) {
+			t.Fatalf("tangent frame at %v not orthonormal", i)
+		}
 	}
-}
 
-func BenchmarkL7(b *testing.B) {
-	for n := 0; n < b.N; n++ {
-		h := New()
-		h.SubDivide(7)
+	h.Surface = Displaced{nil, func(v Vec3) float64 {
+		return noise.OctaveNoise3d(v.X, v.Y, v.Z, 5, 0.8, 1.3) / 4
+	}}
+	deviates := false
+	for i, n := range h.Normals() {
+		if n.Dot(h.Vertices[i]) < 0.99 {
+			deviates = true
+		}
+	}
+	if !deviates {
+		t.Fatal("expected normals of displaced surface to deviate from sphere")
 	}
 }
 
-func BenchmarkL9(b *testing.B) {
-	for n := 0; n < b.N; n++ {
-		h := New()
-		h.SubDivide(9)
-	}
+func testL11Info(t *testing.T) {
+	h := New()
+	h.SubDivide(11)
+	t.Logf("\n\n   Trees: %v\nVertices:  %v\n Indices: %v\n   Edges: %v\n\n", len(h.Trees), len(h.Vertices), len(h.Indices()), h.Edges.Len())
 }
 
-func BenchmarkL11(b *testing.B) {
-	for n := 0; n < b.N; n++ {
-		h := New()
-		h.SubDivide(11)
+func TestEdges(t *testing.T) {
+	ed := &Edges{}
+	for i := 0; i < 20; i++ {
+		ok, err := ed.Init(i, 100)
+		if err != nil || !ok {
+			t.Fatalf("failed to init edge (%v, 100): %v", i, err)
+		}
+	}
+	if ok, _ := ed.Init(100, 3); ok {
+		t.Fatal("expected existing edge")
+	}
+	if n := ed.Len(); n != 20 {
+		t.Fatalf("expected 20 edges but have %v", n)
+	}
+
+	if _, ok := ed.Mid(100, 15); ok {
+		t.Fatal("expected edge without midpoint")
+	}
+	if err := ed.SetMid(15, 100, 200); err != nil {
+		t.Fatal(err)
+	}
+	if mid, ok := ed.Mid(100, 15); !ok || mid != 200 {
+		t.Fatalf("expected midpoint 200 but have %v", mid)
+	}
+
+	// removing inline edges pulls in overflow edges
+	ed.remove(100, 2)
+	ed.remove(100, 4)
+	if n := ed.Len(); n != 18 {
+		t.Fatalf("expected 18 edges but have %v", n)
+	}
+	for i := 0; i < 20; i++ {
+		if x := ed.find(i, 100); (x == nil) != (i == 2 || i == 4) {
+			t.Fatalf("unexpected edge (%v, 100): %+v", i, x)
+		}
+	}
+	if mid, ok := ed.Mid(100, 15); !ok || mid != 200 {
+		t.Fatalf("expected midpoint 200 after removal but have %v", mid)
+	}
+
+	if _, err := ed.Init(3, 3); err == nil {
+		t.Fatal("expected error for degenerate edge")
+	}
+	if _, err := ed.Init(-1, 3); err == nil {
+		t.Fatal("expected error for negative indice")
+	}
+	if err := ed.SetMid(50, 60, 1); err == nil {
+		t.Fatal("expected error for uninitialized edge")
+	}
+}
+
+// TestNorthPole subdivides and culls the nodes around vertex zero, the north pole, and tree zero
+// which are valid and must not be mistaken for empty.
+func TestNorthPole(t *testing.T) {
+	h := New()
+	for idx := 4; idx < 8; idx++ {
+		SubDivide(h, idx, 4)
+	}
+	SubDivide(h, 0, 3)
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+	for idx, tr := range h.Trees {
+		if tr.Empty() || !tr.Leaf() {
+			continue
+		}
+		i0, i1, i2 := h.IndicesAt(idx)
+		for _, e := range [][2]int{{i1, i2}, {i0, i2}, {i0, i1}} {
+			if h.Edges.find(e[0], e[1]) == nil {
+				t.Fatalf("edge %v of leaf %+v not found", e, tr)
+			}
+		}
+	}
+	if _, ok := h.Edges.Mid(1, 0); !ok {
+		t.Fatal("expected midpoint of edge (1, 0) at north pole")
+	}
+
+	for idx := 4; idx < 8; idx++ {
+		Cull(h, idx)
+	}
+	Cull(h, 0)
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+	for idx, tr := range h.Trees[:8] {
+		if tr.Empty() || !tr.Leaf() || tr.Parent != -1 {
+			t.Fatalf("expected live root leaf at %v, have %+v", idx, tr)
+		}
+	}
+	tr, _ := h.TreeCount()
+	vt, _ := h.VertexCount()
+	if tr != 8 || vt != 6 {
+		t.Fatalf("expected 8 trees and 6 vertices after cull but have %v and %v", tr, vt)
+	}
+	for _, e := range [][2]int{{1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 1}} {
+		x := h.Edges.find(e[0], e[1])
+		if x == nil || x.Mid != -1 {
+			t.Fatalf("expected edge %v without midpoint, have %+v", e, x)
+		}
+	}
+
+	// slots freed by the cull are reused and the result matches a fresh subdivision
+	h.Compact()
+	h.SubDivide(3)
+	a := New()
+	a.SubDivide(3)
+	if err := compareHTMs(h, a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangeLog(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	indices := h.SlotIndices()
+	vertices := append([]Vec3(nil), h.Vertices...)
+
+	var log ChangeLog
+	h.OnChange = log.Record
+
+	a, err := h.LookupByCart(Vec3{0.3, 0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, a.Index, 6)
+	Cull(h, 5)
+	SubDivide(h, 2, 5)
+
+	// patch buffers from dirty ranges only
+	trees, verts := log.Dirty(2)
+	if len(trees) == 0 || len(verts) == 0 {
+		t.Fatal("expected dirty ranges")
+	}
+	for _, r := range trees {
+		for len(indices) < r.End*3 {
+			indices = append(indices, 0)
+		}
+		for idx := r.Start; idx < r.End; idx++ {
+			indices[idx*3], indices[idx*3+1], indices[idx*3+2] = h.SlotIndicesAt(idx)
+		}
+	}
+	for _, r := range verts {
+		for len(vertices) < r.End {
+			vertices = append(vertices, Vec3{})
+		}
+		copy(vertices[r.Start:r.End], h.Vertices[r.Start:r.End])
+	}
+
+	expect := h.SlotIndices()
+	if len(indices) != len(expect) {
+		t.Fatalf("expected %v indices but have %v", len(expect), len(indices))
+	}
+	for i := range expect {
+		if indices[i] != expect[i] {
+			t.Fatalf("patched indices differ at %v: have %v, want %v", i, indices[i], expect[i])
+		}
+	}
+	if len(vertices) != len(h.Vertices) {
+		t.Fatalf("expected %v vertices but have %v", len(h.Vertices), len(vertices))
+	}
+	for i, v := range h.Vertices {
+		if !vertices[i].Equals(v) {
+			t.Fatalf("patched vertex %v differs", i)
+		}
+	}
+
+	// slot indices draw the same triangles as Indices
+	n := 0
+	for i := 0; i < len(expect); i += 3 {
+		if expect[i] != 0 || expect[i+1] != 0 || expect[i+2] != 0 {
+			n += 3
+		}
+	}
+	if n != len(h.Indices()) {
+		t.Fatalf("expected %v slot indices but have %v", len(h.Indices()), n)
+	}
+
+	log.Reset()
+	if trees, verts := log.Dirty(0); trees != nil || verts != nil {
+		t.Fatal("expected no dirty ranges after reset")
+	}
+}
+
+func TestSubDivideParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// each step is run on a sequential and a parallel HTM which must stay identical
+	steps := []func(h *HTM, sub func(h *HTM, idx, level int)){
+		func(h *HTM, sub func(h *HTM, idx, level int)) {
+			for idx := 0; idx < h.Roots(); idx++ {
+				sub(h, idx, 4)
+			}
+		},
+		func(h *HTM, sub func(h *HTM, idx, level int)) {
+			tr, _ := h.LookupByCart(Vec3{0.3, 0.2, 0.9})
+			sub(h, tr.Index, 7)
+			Cull(h, 5)
+			Cull(h, 2)
+			sub(h, 4, 6)
+		},
+		func(h *HTM, sub func(h *HTM, idx, level int)) {
+			for idx := 0; idx < h.Roots(); idx++ {
+				sub(h, idx, 6)
+			}
+		},
+	}
+	for _, build := range []func() *HTM{New, NewIcosahedron} {
+		a, b := build(), build()
+		var ca, cb []Change
+		a.OnChange = func(c Change) { ca = append(ca, c) }
+		b.OnChange = func(c Change) { cb = append(cb, c) }
+		for i, step := range steps {
+			step(a, SubDivide)
+			step(b, SubDivideParallel)
+			if err := compareExact(a, b); err != nil {
+				t.Fatalf("step %v: %v", i, err)
+			}
+			if len(ca) != len(cb) {
+				t.Fatalf("step %v: expected %v changes but have %v", i, len(ca), len(cb))
+			}
+			for k := range ca {
+				if ca[k] != cb[k] {
+					t.Fatalf("step %v: change %v is %+v, want %+v", i, k, cb[k], ca[k])
+				}
+			}
+		}
+	}
+}
+
+// compareExact returns an error if a and b differ in any index, including empty slots.
+func compareExact(a, b *HTM) error {
+	if len(a.Vertices) != len(b.Vertices) || len(a.Trees) != len(b.Trees) {
+		return fmt.Errorf("lengths differ: %v/%v vertices, %v/%v trees", len(a.Vertices), len(b.Vertices), len(a.Trees), len(b.Trees))
+	}
+	for i := range a.Vertices {
+		if a.Vertices[i] != b.Vertices[i] {
+			return fmt.Errorf("vertex %v differs", i)
+		}
+	}
+	for i := range a.Trees {
+		if !a.Trees[i].Equals(b.Trees[i]) || a.Trees[i].Flags != b.Trees[i].Flags {
+			return fmt.Errorf("tree %v differs: %+v, %+v", i, a.Trees[i], b.Trees[i])
+		}
+	}
+	ea, eb := a.Edges.nonempty(), b.Edges.nonempty()
+	if len(ea) != len(eb) {
+		return fmt.Errorf("expected %v edges but have %v", len(ea), len(eb))
+	}
+	for i := range ea {
+		if ea[i] != eb[i] {
+			return fmt.Errorf("edge %v differs: %+v, %+v", i, ea[i], eb[i])
+		}
+	}
+	for _, x := range [][2][]int{{a.refs, b.refs}, {a.freeTrees, b.freeTrees}, {a.freeVertices, b.freeVertices}} {
+		if len(x[0]) != len(x[1]) {
+			return fmt.Errorf("bookkeeping lengths differ: %v, %v", len(x[0]), len(x[1]))
+		}
+		for i := range x[0] {
+			if x[0][i] != x[1][i] {
+				return fmt.Errorf("bookkeeping differs at %v: %v, %v", i, x[0][i], x[1][i])
+			}
+		}
+	}
+	return nil
+}
+
+func TestClone(t *testing.T) {
+	h := New()
+	h.SubDivide(4)
+	Cull(h, 3)
+	c := h.Clone()
+	if err := compareExact(h, c); err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(c, 3, 6)
+	Cull(c, 0)
+
+	a := New()
+	a.SubDivide(4)
+	Cull(a, 3)
+	if err := compareExact(h, a); err != nil {
+		t.Fatalf("original modified by clone: %v", err)
+	}
+}
+
+func TestShared(t *testing.T) {
+	// snapshots alternate between tree 4 culled and subdivided
+	h := New()
+	h.SubDivide(3)
+	Cull(h, 4)
+	coarse := len(h.Indices())
+	x := h.Clone()
+	SubDivide(x, 4, 6)
+	fine := len(x.Indices())
+	s := NewShared(h)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap := s.Load()
+				if n := len(snap.Indices()); n != coarse && n != fine {
+					t.Errorf("inconsistent snapshot with %v indices", n)
+					return
+				}
+				for _, idx := range snap.Intersections(cn) {
+					v0, v1, v2 := snap.VerticesAt(idx)
+					if v0.Equals(Vec3Zero) || v1.Equals(Vec3Zero) || v2.Equals(Vec3Zero) {
+						t.Errorf("intersection %v has empty vertex", idx)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		s.Update(func(h *HTM) { SubDivide(h, 4, 6) })
+		s.Update(func(h *HTM) { Cull(h, 4) })
+	}
+	close(done)
+	wg.Wait()
+
+	if err := validateHTM(s.Load()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIterators(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	SubDivide(h, 4, 5)
+
+	var ch []int
+	for idx := range Iter(h, 0, 1, 2, 3, 4, 5, 6, 7) {
+		ch = append(ch, idx)
+	}
+	leaves := slices.Collect(h.Leaves())
+	if !slices.Equal(ch, leaves) || len(leaves)*3 != len(h.Indices()) {
+		t.Fatalf("Leaves differs from Iter: %v, %v", len(leaves), len(ch))
+	}
+	n := 0
+	for range h.Leaves() {
+		if n++; n == 3 {
+			break
+		}
+	}
+
+	if lvl := slices.Collect(AtLevel(h, 4, 2)); len(lvl) != 4 || !slices.Equal(lvl, IterLevel(h, 4, 2)) {
+		t.Fatalf("expected 4 nodes at level 2 but have %v", lvl)
+	}
+
+	leaf := leaves[len(leaves)/2]
+	var levels []int
+	for p := range Ancestors(h, leaf) {
+		levels = append(levels, h.Trees[p].Level)
+	}
+	if len(levels) != h.Trees[leaf].Level-1 || levels[len(levels)-1] != 1 {
+		t.Fatalf("unexpected ancestor levels %v of leaf at level %v", levels, h.Trees[leaf].Level)
+	}
+
+	cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
+	var mt []int
+	for idx, cv := range h.Intersecting(cn) {
+		if cv == Partial && !h.Trees[idx].Leaf() {
+			t.Fatalf("partial node %v is not a leaf", idx)
+		}
+		mt = append(mt, idx)
+	}
+	if !slices.Equal(mt, h.Intersections(cn)) {
+		t.Fatal("Intersecting differs from Intersections")
+	}
+
+	// neighbors are symmetric and, for leaves of equal level, share an edge
+	finer := 0
+	for _, idx := range leaves {
+		ns := slices.Collect(Neighbors(h, idx))
+		if len(ns) < 3 {
+			t.Fatalf("leaf %v has %v neighbors", idx, len(ns))
+		}
+		if len(ns) > 3 {
+			finer++
+		}
+		for _, x := range ns {
+			if !slices.Contains(slices.Collect(Neighbors(h, x)), idx) {
+				t.Fatalf("leaf %v is a neighbor of %v but not the reverse", x, idx)
+			}
+			if h.Trees[x].Level == h.Trees[idx].Level {
+				shared := 0
+				for _, i := range h.Trees[x].Indices {
+					if h.Trees[idx].has(i) {
+						shared++
+					}
+				}
+				if shared != 2 {
+					t.Fatalf("neighbors %v and %v share %v vertices", idx, x, shared)
+				}
+			}
+		}
+	}
+	if finer == 0 {
+		t.Fatal("expected leaves with finer neighbors")
+	}
+	if coarse := slices.Collect(Neighbors(h, leaves[len(leaves)-1])); len(coarse) != 3 {
+		t.Fatalf("expected 3 neighbors of uniform leaf but have %v", coarse)
+	}
+	ico := NewIcosahedron()
+	for idx := 0; idx < ico.Roots(); idx++ {
+		if ns := slices.Collect(Neighbors(ico, idx)); len(ns) != 3 {
+			t.Fatalf("root %v has %v neighbors", idx, len(ns))
+		}
+	}
+}
+
+func TestVec3(t *testing.T) {
+	a, b := Vec3{1, 2, 3}, Vec3{-2, 0.5, 4}
+	if c := a.Cross(b); !equal(c.Dot(a), 0) || !equal(c.Dot(b), 0) {
+		t.Fatalf("cross product %v not orthogonal", c)
+	}
+	if n, ok := a.Normalized(); !ok || !equal(n.Length(), 1) {
+		t.Fatalf("expected unit vector but have %v", n)
+	}
+	if _, ok := Vec3Zero.Normalized(); ok {
+		t.Fatal("expected zero vector to fail normalization")
+	}
+	if !a.Equals(a.Add(Vec3{1e-12, 0, 0})) || a.Equals(b) {
+		t.Fatal("unexpected equality")
+	}
+
+	fs := Float32s([]Vec3{a, b})
+	if len(fs) != 6 || fs[3] != -2 || fs[5] != 4 {
+		t.Fatalf("unexpected float32s %v", fs)
+	}
+	if v := Vec3fs([]Vec3{b})[0].Vec3(); v != b {
+		t.Fatalf("expected %v but have %v", b, v)
+	}
+}
+
+// leafTriangles returns the vertices of each leaf of h, sorted for comparison.
+func leafTriangles(vs []Vec3, indices []uint32) [][3]Vec3 {
+	var tris [][3]Vec3
+	for i := 0; i < len(indices); i += 3 {
+		tris = append(tris, [3]Vec3{vs[indices[i]], vs[indices[i+1]], vs[indices[i+2]]})
+	}
+	sort.Slice(tris, func(i, j int) bool {
+		for k := 0; k < 3; k++ {
+			a, b := tris[i][k], tris[j][k]
+			if a != b {
+				return a.X < b.X || (a.X == b.X && (a.Y < b.Y || (a.Y == b.Y && a.Z < b.Z)))
+			}
+		}
+		return false
+	})
+	return tris
+}
+
+func TestPacked(t *testing.T) {
+	h := New()
+	h.SubDivide(4)
+	tr, err := h.LookupByCart(Vec3{0.3, 0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, tr.Index, 7)
+	Cull(h, 2)
+
+	p := Pack(h)
+	if p.Complete != 1 || p.Len()*3 != len(p.Indices) {
+		t.Fatalf("unexpected layout with %v complete levels", p.Complete)
+	}
+	want := leafTriangles(h.Vertices, h.Indices())
+	have := leafTriangles(p.Vertices, p.LeafIndices())
+	if len(want) != len(have) {
+		t.Fatalf("expected %v leaves but have %v", len(want), len(have))
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			t.Fatalf("leaf %v differs", i)
+		}
+	}
+
+	for _, v := range []Vec3{{0.3, 0.2, 0.9}, {-0.5, 0.1, -0.3}, {0.7, -0.6, 0.01}} {
+		tr, err := h.LookupByCart(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i, err := p.LookupByCart(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v0, v1, v2 := p.VerticesAt(i)
+		w0, w1, w2 := h.VerticesFor(tr)
+		if v0 != w0 || v1 != w1 || v2 != w2 || p.Level(i) != tr.Level {
+			t.Fatalf("lookup of %v differs", v)
+		}
+	}
+	cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
+	if a, b := h.Intersections(cn), p.Intersections(cn); len(a) != len(b) {
+		t.Fatalf("expected %v intersections but have %v", len(a), len(b))
+	}
+
+	// uniform subdivision stores children implicitly
+	u := New()
+	u.SubDivide(5)
+	p32 := PackFloat32(u)
+	if p32.Complete != 5 || len(p32.Children) != 8*256 || p32.Vertices != nil {
+		t.Fatalf("expected 5 complete levels but have %v with %v children", p32.Complete, len(p32.Children))
+	}
+	if i, err := p32.LookupByCart(Vec3{0.3, 0.2, 0.9}); err != nil || !p32.Leaf(i) || p32.Level(i) != 5 {
+		t.Fatalf("lookup failed at %v: %v", i, err)
+	}
+
+	// unpacked meshes subdivide as the original
+	x := p.Unpack()
+	if err := validateHTM(x); err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(x, 2, 4)
+	SubDivide(h, 2, 4)
+	want = leafTriangles(h.Vertices, h.Indices())
+	have = leafTriangles(x.Vertices, x.Indices())
+	if len(want) != len(have) {
+		t.Fatalf("expected %v leaves after unpack but have %v", len(want), len(have))
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			t.Fatalf("leaf %v differs after unpack", i)
+		}
+	}
+	if err := validateHTM(x); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBinary(t *testing.T) {
+	adaptive := New()
+	adaptive.SubDivide(4)
+	tr, err := adaptive.LookupByCart(Vec3{0.3, 0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(adaptive, tr.Index, 7)
+	Cull(adaptive, 2)
+	Cull(adaptive, 6)
+
+	uniform := New()
+	uniform.SubDivide(5)
+	ico := NewIcosahedron()
+	ico.SubDivide(3)
+
+	for _, h := range []*HTM{uniform, adaptive, ico} {
+		var buf bytes.Buffer
+		if _, err := h.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		data := append([]byte(nil), buf.Bytes()...)
+
+		x := &HTM{}
+		if _, err := x.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := compareExact(h, x); err != nil {
+			t.Fatal(err)
+		}
+		if err := compareHTMs(h, x); err != nil {
+			t.Fatal(err)
+		}
+
+		// decoded meshes continue to subdivide and cull identically
+		SubDivide(x, 6, 6)
+		Cull(x, 0)
+		y := h.Clone()
+		SubDivide(y, 6, 6)
+		Cull(y, 0)
+		if err := compareExact(x, y); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, corrupt := range []func([]byte) []byte{
+			func(b []byte) []byte { b[len(b)/2] ^= 1; return b },
+			func(b []byte) []byte { return b[:len(b)-8] },
+			func(b []byte) []byte { b[4] = 2; return b },
+			func(b []byte) []byte { b[0] = 'X'; return b },
+		} {
+			if err := (&HTM{}).UnmarshalBinary(corrupt(append([]byte(nil), data...))); err == nil {
+				t.Fatal("expected error decoding corrupt data")
+			}
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	h := New()
+	h.SubDivide(4)
+	tr, err := h.LookupByCart(Vec3{0.3, 0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDivide(h, tr.Index, 8)
+	Cull(h, 2)
+
+	name := filepath.Join(t.TempDir(), "mesh.htm")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := OpenView(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	for _, p := range []Vec3{{0.3, 0.2, 0.9}, {-0.5, 0.1, -0.3}, {0.7, -0.6, 0.01}} {
+		a, err := h.LookupByCart(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := v.LookupByCart(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Equals(b) {
+			t.Fatalf("lookup of %v differs: %+v, %+v", p, a, b)
+		}
+	}
+	cn := &Constraint{Vec3{0.001, 0.001, 0.999}, 0.85}
+	if a, b := h.Intersections(cn), v.Intersections(cn); !slices.Equal(a, b) {
+		t.Fatalf("intersections differ: %v, %v", len(a), len(b))
+	}
+	roots := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if a, b := slices.Collect(Leaves(h, roots...)), slices.Collect(v.Leaves(roots...)); !slices.Equal(a, b) {
+		t.Fatalf("leaves differ: %v, %v", len(a), len(b))
+	}
+
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/3] ^= 0x80
+	if _, err := NewView(data); err == nil {
+		t.Fatal("expected error viewing corrupt data")
+	}
+
+	// a parent sharing the children of another would have traversals visit them twice
+	g := New()
+	g.SubDivide(3)
+	g.Trees[1].Children = g.Trees[0].Children
+	if data, err = g.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewView(data); err == nil {
+		t.Fatal("expected error viewing shared children")
+	}
+	if err := New().UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error decoding shared children")
+	}
+
+	// free lists naming live or repeated entries would have them handed out twice
+	leaf := slices.Collect(h.Leaves())[0]
+	for _, corrupt := range []func(x *HTM){
+		func(x *HTM) { x.freeTrees = append(x.freeTrees, leaf) },
+		func(x *HTM) { x.freeTrees = append(x.freeTrees, x.freeTrees[0]) },
+		func(x *HTM) { x.freeVertices = append(x.freeVertices, x.Trees[leaf].Indices[0]) },
+		func(x *HTM) { x.freeVertices = append(x.freeVertices, x.freeVertices[0]) },
+	} {
+		x := h.Clone()
+		corrupt(x)
+		if data, err = x.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		}
+		if err := New().UnmarshalBinary(data); err == nil {
+			t.Fatal("expected error decoding corrupt free list")
+		}
+	}
+}
+
+func TestOBJ(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	Cull(h, 5)
+	SubDivide(h, 2, 4)
+
+	var buf bytes.Buffer
+	if err := h.WriteOBJ(&buf, OBJOptions{TexCoords: true, Normals: true}); err != nil {
+		t.Fatal(err)
+	}
+	var v, vt, vn, f int
+	for _, line := range strings.Split(buf.String(), "\n") {
+		switch strings.SplitN(line, " ", 2)[0] {
+		case "v":
+			v++
+		case "vt":
+			vt++
+		case "vn":
+			vn++
+		case "f":
+			f++
+		}
+	}
+	if live, _ := h.VertexCount(); v != live || vt != v || vn != v || f*3 != len(h.Indices()) {
+		t.Fatalf("unexpected counts v %v, vt %v, vn %v, f %v", v, vt, vn, f)
+	}
+
+	// leaves of unequal levels do not share whole edges, so only a uniform mesh reads back
+	_, err := ReadOBJ(&buf)
+	if err == nil {
+		t.Fatal("expected error reading mesh with T-junctions")
+	}
+	h = New()
+	h.SubDivide(3)
+	buf.Reset()
+	if err := h.WriteOBJ(&buf, OBJOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	x, err := ReadOBJ(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f = len(h.Indices()) / 3; x.Roots() != f {
+		t.Fatalf("expected %v roots but have %v", f, x.Roots())
+	}
+	want := leafTriangles(h.Vertices, h.Indices())
+	have := leafTriangles(x.Vertices, x.Indices())
+	for i := range want {
+		if want[i] != have[i] {
+			t.Fatalf("triangle %v differs: %v, %v", i, want[i], have[i])
+		}
+	}
+
+	// negative and slash separated references
+	x, err = ReadOBJ(strings.NewReader(`# tetrahedron
+v 1 1 1
+v -1 -1 1
+v -1 1 -1
+v 1 -1 -1
+f 1/1 2/2 3/3
+f -4//1 -1//1 -3//1
+f 1 3 4
+f 2 4 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.Midpoint = FlatMidpoint
+	x.SubDivide(3)
+	if err := validateHTM(x); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{
+		"v 1 0 0\nv 0 1 0\nv 0 0 1\nf 1 2 3\n",
+		"v 1 0 0\nv 0 1 0\nv 0 0 1\nv 1 1 1\nf 1 2 3 4\n",
+		"v 1 0\n",
+		"v 1 0 0\nf 1 2 x\n",
+	} {
+		if _, err := ReadOBJ(strings.NewReader(src)); err == nil {
+			t.Fatalf("expected error reading %q", src)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	h := New()
+	for r := 0; r < h.Roots(); r++ {
+		if id := h.ID(r); id != uint64(8+r) {
+			t.Fatalf("root %v has ID %v", r, id)
+		}
+	}
+	h.SubDivide(3)
+	Cull(h, 5)
+	SubDivide(h, 2, 5)
+	for k, c := range h.Trees[0].Children {
+		if id := h.ID(c); id != uint64(32+k) {
+			t.Fatalf("child %v of root 0 has ID %v", k, id)
+		}
+	}
+	for idx, tr := range h.Trees {
+		if tr.Empty() {
+			continue
+		}
+		id := h.ID(idx)
+		if bits.Len64(id) != 4+2*(tr.Level-1) {
+			t.Fatalf("node %v at level %v has ID %b", idx, tr.Level, id)
+		}
+		x, err := h.Trixel(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x != idx {
+			t.Fatalf("trixel %v of node %v is %v", id, idx, x)
+		}
+	}
+	for _, id := range []uint64{0, 1, 7, 16, 32 << 20} {
+		if _, err := h.Trixel(id); err == nil {
+			t.Fatalf("expected error for ID %v", id)
+		}
+	}
+}
+
+func TestGLB(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	Cull(h, 5)
+	SubDivide(h, 2, 4)
+	leaves := len(h.Indices()) / 3
+	levels := make(map[int]bool)
+	for idx := range h.Leaves() {
+		levels[h.Trees[idx].Level] = true
+	}
+
+	for _, split := range []GLBSplit{SplitNone, SplitRoots, SplitLevels} {
+		var buf bytes.Buffer
+		if err := h.WriteGLB(&buf, GLBOptions{Normals: true, TexCoords: true, Split: split}); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		le := binary.LittleEndian
+		if le.Uint32(data) != 0x46546c67 || le.Uint32(data[4:]) != 2 || int(le.Uint32(data[8:])) != len(data) {
+			t.Fatalf("invalid header % x", data[:12])
+		}
+		n := int(le.Uint32(data[12:]))
+		if le.Uint32(data[16:]) != 0x4e4f534a || n%4 != 0 {
+			t.Fatalf("invalid JSON chunk")
+		}
+		bin := data[20+n+8:]
+		if int(le.Uint32(data[20+n:])) != len(bin) || le.Uint32(data[24+n:]) != 0x004e4942 {
+			t.Fatalf("invalid BIN chunk")
+		}
+
+		var doc struct {
+			Accessors   []gltfAccessor
+			BufferViews []gltfBufferView
+			Meshes      []struct {
+				Primitives []gltfPrimitive
+			}
+			Nodes []struct {
+				Mesh   int
+				Extras struct {
+					Trixels []uint64
+					Trixel  *uint64
+					Level   *int
+				}
+			}
+		}
+		if err := json.Unmarshal(data[20:20+n], &doc); err != nil {
+			t.Fatal(err)
+		}
+		for _, acc := range doc.Accessors {
+			if acc.Count == 0 || doc.BufferViews[acc.BufferView].ByteLength == 0 {
+				t.Fatalf("split %v: empty accessor %+v", split, acc)
+			}
+		}
+		if want := map[GLBSplit]int{SplitNone: 1, SplitRoots: h.Roots(), SplitLevels: len(levels)}[split]; len(doc.Nodes) != want {
+			t.Fatalf("split %v: expected %v nodes but have %v", split, want, len(doc.Nodes))
+		}
+		total := 0
+		for _, node := range doc.Nodes {
+			if len(doc.Meshes[node.Mesh].Primitives) != 1 {
+				t.Fatalf("expected one primitive of node mesh %v", node.Mesh)
+			}
+			p, extras := doc.Meshes[node.Mesh].Primitives[0], node.Extras
+			for _, name := range []string{"POSITION", "NORMAL", "TEXCOORD_0"} {
+				if _, ok := p.Attributes[name]; !ok {
+					t.Fatalf("missing attribute %v", name)
+				}
+			}
+			acc := doc.Accessors[p.Indices]
+			if acc.Count != len(extras.Trixels)*3 {
+				t.Fatalf("%v indices for %v trixels", acc.Count, len(extras.Trixels))
+			}
+			view := doc.BufferViews[acc.BufferView]
+			for k, id := range extras.Trixels {
+				idx, err := h.Trixel(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !h.Trees[idx].Leaf() {
+					t.Fatalf("trixel %v is not a leaf", id)
+				}
+				pos := doc.Accessors[p.Attributes["POSITION"]]
+				pview := doc.BufferViews[pos.BufferView]
+				for j, vi := range h.Trees[idx].Indices {
+					x := le.Uint32(bin[view.ByteOffset+(k*3+j)*4:])
+					off := pview.ByteOffset + int(x)*12
+					want := h.PositionAt(vi).Vec3f()
+					have := Vec3f{
+						math.Float32frombits(le.Uint32(bin[off:])),
+						math.Float32frombits(le.Uint32(bin[off+4:])),
+						math.Float32frombits(le.Uint32(bin[off+8:])),
+					}
+					if want != have {
+						t.Fatalf("trixel %v vertex %v is %v, expected %v", id, j, have, want)
+					}
+				}
+			}
+			switch split {
+			case SplitRoots:
+				if extras.Trixel == nil {
+					t.Fatal("missing root trixel")
+				}
+				for _, id := range extras.Trixels {
+					if id>>(bits.Len64(id)-4) != *extras.Trixel {
+						t.Fatalf("trixel %v not below root %v", id, *extras.Trixel)
+					}
+				}
+			case SplitLevels:
+				if extras.Level == nil {
+					t.Fatal("missing level")
+				}
+				for _, id := range extras.Trixels {
+					if idx, _ := h.Trixel(id); h.Trees[idx].Level != *extras.Level {
+						t.Fatalf("trixel %v not at level %v", id, *extras.Level)
+					}
+				}
+			}
+			total += len(extras.Trixels)
+		}
+		if total != leaves {
+			t.Fatalf("split %v: expected %v triangles but have %v", split, leaves, total)
+		}
+	}
+
+	// a mesh without leaves has nothing to put in accessors or buffers
+	var buf bytes.Buffer
+	if err := (&HTM{}).WriteGLB(&buf, GLBOptions{Normals: true, TexCoords: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if n := int(binary.LittleEndian.Uint32(data[12:])); len(data) != 20+n {
+		t.Fatalf("expected only a JSON chunk in %v bytes", len(data))
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data[20:], &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"nodes", "meshes", "accessors", "bufferViews", "buffers"} {
+		if _, ok := doc[key]; ok {
+			t.Fatalf("unexpected %v in empty mesh", key)
+		}
+	}
+}
+
+func TestPLY(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	Cull(h, 5)
+	SubDivide(h, 2, 4)
+	vertices, _ := h.VertexCount()
+	faces := len(h.Indices()) / 3
+	height := PLYProperty{Name: "height", Type: "float", Value: func(i int) float64 { return h.Vertices[i].Z }}
+	opts := PLYOptions{
+		Normals: true,
+		Vertex:  []PLYProperty{height},
+		Face:    []PLYProperty{h.LevelProperty(), h.IDProperty(), h.CoverageProperty(&Constraint{Vec3{0, 0, 1}, 0.5})},
+	}
+
+	var buf bytes.Buffer
+	if err := h.WritePLY(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	header, body, ok := strings.Cut(buf.String(), "end_header\n")
+	if !ok {
+		t.Fatal("missing end_header")
+	}
+	if !strings.Contains(header, fmt.Sprintf("element vertex %d\n", vertices)) || !strings.Contains(header, fmt.Sprintf("element face %d\n", faces)) {
+		t.Fatalf("unexpected header:\n%s", header)
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != vertices+faces {
+		t.Fatalf("expected %v lines but have %v", vertices+faces, len(lines))
+	}
+	// each face is the count, three indices, level, ID and coverage
+	idx := 0
+	for _, line := range lines[vertices:] {
+		for h.Trees[idx].Empty() || !h.Trees[idx].Leaf() {
+			idx++
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 7 || fields[0] != "3" {
+			t.Fatalf("unexpected face %q", line)
+		}
+		if want := fmt.Sprint(h.Trees[idx].Level); fields[4] != want {
+			t.Fatalf("face %q expected level %v", line, want)
+		}
+		if want := fmt.Sprint(h.ID(idx)); fields[5] != want {
+			t.Fatalf("face %q expected ID %v", line, want)
+		}
+		idx++
+	}
+
+	opts.Binary = true
+	buf.Reset()
+	if err := h.WritePLY(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	_, body, _ = strings.Cut(buf.String(), "end_header\n")
+	if n := vertices*7*4 + faces*(1+3*4+1+8+1); len(body) != n {
+		t.Fatalf("expected %v bytes of binary data but have %v", n, len(body))
+	}
+
+	opts.Face = append(opts.Face, PLYProperty{Name: "count", Type: "int64", Value: func(int) float64 { return 0 }})
+	if err := h.WritePLY(&buf, opts); err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+}
+
+func TestSTL(t *testing.T) {
+	h := New()
+	h.SubDivide(3)
+	h.Surface = Displaced{Height: func(v Vec3) float64 { return 0.1 * v.X }}
+	var buf bytes.Buffer
+	if err := h.WriteSTL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	n := len(h.Indices()) / 3
+	if len(data) != 84+50*n || int(binary.LittleEndian.Uint32(data[80:])) != n {
+		t.Fatalf("expected %v triangles in %v bytes", n, len(data))
+	}
+	f := func(b []byte) Vec3 {
+		le := binary.LittleEndian
+		return Vec3{
+			float64(math.Float32frombits(le.Uint32(b))),
+			float64(math.Float32frombits(le.Uint32(b[4:]))),
+			float64(math.Float32frombits(le.Uint32(b[8:]))),
+		}
+	}
+	// normals face outward and agree with the winding
+	for k := 0; k < n; k++ {
+		b := data[84+50*k:]
+		normal, p0, p1, p2 := f(b), f(b[12:]), f(b[24:]), f(b[36:])
+		if normal.Dot(p0) <= 0 || normal.Dot(p1.Sub(p0).Cross(p2.Sub(p0))) <= 0 {
+			t.Fatalf("triangle %v has inward normal %v", k, normal)
+		}
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	type geometry struct {
+		Type        string
+		Coordinates json.RawMessage
+	}
+	type collection struct {
+		Features []struct {
+			Geometry   geometry
+			Properties map[string]float64
+		}
+	}
+	// polygons returns the polygons of a Polygon or MultiPolygon
+	polygons := func(g geometry) [][][][2]float64 {
+		var polys [][][][2]float64
+		if g.Type == "Polygon" {
+			var poly [][][2]float64
+			if err := json.Unmarshal(g.Coordinates, &poly); err != nil {
+				t.Fatal(err)
+			}
+			polys = append(polys, poly)
+		} else if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			t.Fatal(err)
+		}
+		return polys
+	}
+	// area returns the area of polygons in square degrees, checking that rings are closed
+	area := func(polys [][][][2]float64) float64 {
+		var a float64
+		for _, poly := range polys {
+			for i, ring := range poly {
+				if ring[0] != ring[len(ring)-1] {
+					t.Fatalf("ring is not closed: %v", ring)
+				}
+				for _, p := range ring {
+					if math.Abs(p[0]) > 180 || math.Abs(p[1]) > 90 {
+						t.Fatalf("position %v out of range", p)
+					}
+				}
+				if ra := ringArea(ring[:len(ring)-1]); (i == 0) != (ra > 0) {
+					t.Fatalf("ring %v has area %v", i, ra)
+				}
+				a += ringArea(ring[:len(ring)-1])
+			}
+		}
+		return a
+	}
+	contains := func(polys [][][][2]float64, p [2]float64) bool {
+		for _, poly := range polys {
+			if inRing(p, poly[0]) {
+				in := true
+				for _, hole := range poly[1:] {
+					in = in && !inRing(p, hole)
+				}
+				if in {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	lon, lat := LonLat(FromLonLat(-120, 35))
+	if !equal(lon, -120) || !equal(lat, 35) {
+		t.Fatalf("unexpected lon, lat %v, %v", lon, lat)
+	}
+
+	// leaves tile the sphere, including at the poles and the antimeridian
+	for _, h := range []*HTM{New(), NewIcosahedron()} {
+		h.SubDivide(2)
+		var leaves []int
+		for idx := range h.Leaves() {
+			leaves = append(leaves, idx)
+		}
+		var buf bytes.Buffer
+		if err := h.WriteGeoJSON(&buf, leaves); err != nil {
+			t.Fatal(err)
+		}
+		var fc collection
+		if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		if len(fc.Features) != len(leaves) {
+			t.Fatalf("expected %v features but have %v", len(leaves), len(fc.Features))
+		}
+		total := 0.0
+		for i, f := range fc.Features {
+			idx := leaves[i]
+			if uint64(f.Properties["id"]) != h.ID(idx) || int(f.Properties["level"]) != h.Trees[idx].Level {
+				t.Fatalf("unexpected properties %v of node %v", f.Properties, idx)
+			}
+			polys := polygons(f.Geometry)
+			total += area(polys)
+			v0, v1, v2 := h.VerticesAt(idx)
+			lon, lat := LonLat(v0.MulScalar(0.5).Add(v1.MulScalar(0.3)).Add(v2.MulScalar(0.2)))
+			if !contains(polys, [2]float64{lon, lat}) {
+				t.Fatalf("node %v does not contain its center %v, %v: %v", idx, lon, lat, polys)
+			}
+		}
+		if math.Abs(total-360*180) > 1e-6 {
+			t.Fatalf("leaves cover %v square degrees", total)
+		}
+	}
+
+	// regions
+	deg := func(x float64) float64 { return x * 180 / math.Pi }
+	for _, tc := range []struct {
+		region Tester
+		polys  int
+		area   float64
+		in     [][2]float64
+		out    [][2]float64
+	}{
+		{&Constraint{Vec3{0, 0, 2}, 1}, 1, 360 * 60, [][2]float64{{0, 89}, {179, 31}}, [][2]float64{{0, 29}}},
+		{&Constraint{Vec3{0, 0, 1}, -0.5}, 1, 360 * 120, [][2]float64{{0, -29}, {-179, 89}}, [][2]float64{{0, -31}}},
+		{&Constraint{FromLonLat(180, 0), math.Cos(10 * math.Pi / 180)}, 2, -1, [][2]float64{{179, 0}, {-179, 5}}, [][2]float64{{0, 0}, {165, 0}}},
+		{Convex{{Vec3{0, 0, 1}, 0.5}, {Vec3{0, 0, -1}, -0.9}}, 1, 360 * (deg(math.Asin(0.9)) - 30), [][2]float64{{10, 45}}, [][2]float64{{10, 70}, {10, 20}}},
+		{Convex{{Vec3{1, 0, 0}, 0}, {Vec3{0, 1, 0}, 0}, {Vec3{0, 0, 1}, 0}}, 1, 90 * 90, [][2]float64{{45, 45}}, [][2]float64{{-45, 45}, {45, -45}}},
+		{&Constraint{Vec3{0, 0, 1}, -2}, 1, 360 * 180, [][2]float64{{0, 0}}, nil},
+		{&Constraint{Vec3{0, 0, 1}, 2}, 0, 0, nil, [][2]float64{{0, 0}}},
+	} {
+		var buf bytes.Buffer
+		if err := WriteRegionGeoJSON(&buf, tc.region); err != nil {
+			t.Fatal(err)
+		}
+		var fc collection
+		if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		polys := polygons(fc.Features[0].Geometry)
+		if len(polys) != tc.polys {
+			t.Fatalf("%v: expected %v polygons but have %v", tc.region, tc.polys, len(polys))
+		}
+		if a := area(polys); tc.area >= 0 && math.Abs(a-tc.area) > 1e-6*tc.area+1e-9 {
+			t.Fatalf("%v: expected area %v but have %v", tc.region, tc.area, a)
+		}
+		for _, p := range tc.in {
+			if !contains(polys, p) {
+				t.Fatalf("%v: expected %v inside", tc.region, p)
+			}
+		}
+		for _, p := range tc.out {
+			if contains(polys, p) {
+				t.Fatalf("%v: expected %v outside", tc.region, p)
+			}
+		}
+	}
+	if err := WriteRegionGeoJSON(io.Discard, nil); err == nil {
+		t.Fatal("expected error for unsupported region")
+	}
+
+	// import
+	d, err := ReadGeoJSON(strings.NewReader(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {}, "geometry": {"type": "Polygon", "coordinates":
+			[[[10, 10], [10, 30], [20, 30], [20, 20], [30, 20], [30, 10], [10, 10]]]}},
+		{"type": "Feature", "properties": {}, "geometry": {"type": "MultiPolygon", "coordinates":
+			[[[[-60, -10], [-50, -10], [-50, 0], [-55, 0, 100], [-60, 0]]]]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (-55, 0) is on the great circle between its neighbours
+	if len(d) != 4+2 {
+		t.Fatalf("expected 6 triangles but have %v", len(d))
+	}
+	test := func(lon, lat float64) Coverage {
+		p := FromLonLat(lon, lat)
+		return d.Test(p, p, p)
+	}
+	for _, p := range [][2]float64{{15, 25}, {25, 15}, {11, 11}, {-55, -5}} {
+		if test(p[0], p[1]) != Inside {
+			t.Fatalf("expected %v inside", p)
+		}
+	}
+	for _, p := range [][2]float64{{25, 25}, {5, 15}, {-45, -5}, {-55, 5}} {
+		if test(p[0], p[1]) != Outside {
+			t.Fatalf("expected %v outside", p)
+		}
+	}
+
+	// points and lines among polygons are skipped, as in mixed layer exports
+	mixed, err := ReadGeoJSON(strings.NewReader(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [5, 5]}},
+		{"type": "Feature", "properties": {}, "geometry": null},
+		{"type": "Feature", "properties": {}, "geometry": {"type": "GeometryCollection", "geometries": [
+			{"type": "LineString", "coordinates": [[0, 0], [10, 0]]},
+			{"type": "Polygon", "coordinates": [[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]]]}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mixed) != 2 {
+		t.Fatalf("expected 2 triangles but have %v", len(mixed))
+	}
+
+	// rings crossing the antimeridian keep their winding, either way round
+	for _, src := range []string{
+		`{"type": "Polygon", "coordinates": [[[170, 0], [-170, 0], [-170, 10], [170, 10], [170, 0]]]}`,
+		`{"type": "Polygon", "coordinates": [[[170, 0], [170, 10], [-170, 10], [-170, 0], [170, 0]]]}`,
+	} {
+		am, err := ReadGeoJSON(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range [][2]float64{{180, 5}, {175, 2}, {-175, 8}} {
+			if v := FromLonLat(p[0], p[1]); am.Test(v, v, v) != Inside {
+				t.Fatalf("expected %v inside %s", p, src)
+			}
+		}
+		for _, p := range [][2]float64{{0, 5}, {160, 5}, {180, -5}} {
+			if v := FromLonLat(p[0], p[1]); am.Test(v, v, v) != Outside {
+				t.Fatalf("expected %v outside %s", p, src)
+			}
+		}
+	}
+
+	// a region read back contains the same positions
+	var buf bytes.Buffer
+	if err := WriteRegionGeoJSON(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	x, err := ReadGeoJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lon := -70.05; lon < 40; lon += 0.5 {
+		for lat := -20.05; lat < 40; lat += 0.5 {
+			p := FromLonLat(lon, lat)
+			if want, have := d.Test(p, p, p), x.Test(p, p, p); want != have {
+				t.Fatalf("%v, %v: expected %v but have %v", lon, lat, want, have)
+			}
+		}
+	}
+
+	for _, src := range []string{
+		`{"type": "Polygon", "coordinates": [[[0, 0], [10, 0], [10, 10], [0, 0]], [[1, 1], [2, 1], [2, 2], [1, 1]]]}`,
+		`{"type": "Polygon", "coordinates": [[[0, 0], [10, 0], [0, 0]]]}`,
+		`{"type": "LineString", "coordinates": [[0, 0], [10, 0]]}`,
+		`{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": {"type": "Point", "coordinates": [5, 5]}}]}`,
+		`{"type": "Feature", "geometry": {"type": "Circle", "coordinates": [5, 5]}}`,
+		`{"type": "Polygon", "coordinates": [[[0], [10, 0], [10, 10], [0, 0]]]}`,
+	} {
+		if _, err := ReadGeoJSON(strings.NewReader(src)); err == nil {
+			t.Fatalf("expected error reading %s", src)
+		}
+	}
+}
+
+func TestHEALPix(t *testing.T) {
+	// centers of the base pixels
+	for face, want := range []struct{ lon, lat float64 }{
+		{45, 41.8}, {135, 41.8}, {-135, 41.8}, {-45, 41.8},
+		{0, 0}, {90, 0}, {180, 0}, {-90, 0},
+		{45, -41.8}, {135, -41.8}, {-135, -41.8}, {-45, -41.8},
+	} {
+		lon, lat := LonLat(HEALPixCenter(0, uint64(face)))
+		if math.Abs(math.Remainder(lon-want.lon, 360)) > 0.1 || math.Abs(lat-want.lat) > 0.1 {
+			t.Fatalf("face %v center at %v, %v", face, lon, lat)
+		}
+	}
+	for _, order := range []int{0, 3, 10, 29} {
+		for p := uint64(0); p < 12<<(2*order); p += 1 + 12<<(2*order)/997 {
+			face, x, y := nestXY(order, p)
+			if xyNest(order, face, x, y) != p {
+				t.Fatalf("order %v pixel %v not recovered from %v, %v, %v", order, p, face, x, y)
+			}
+			if q := HEALPixNest(order, HEALPixCenter(order, p)); q != p {
+				t.Fatalf("order %v center of pixel %v is in %v", order, p, q)
+			}
+		}
+	}
+	// pixels contain their points and pixels of equal order have equal area
+	r := rand.New(rand.NewSource(1))
+	counts := make([]int, 12<<4)
+	for i := 0; i < 20000; i++ {
+		v, _ := Vec3{r.NormFloat64(), r.NormFloat64(), r.NormFloat64()}.Normalized()
+		p := HEALPixNest(7, v)
+		if bc := pixelCap(7, p); bc.c.Dot(v) < bc.cos {
+			t.Fatalf("%v outside cap of its pixel %v", v, p)
+		}
+		if HEALPixNest(5, v) != p>>4 {
+			t.Fatalf("%v in pixel %v but not its parent", v, p)
+		}
+		counts[p>>10]++
+	}
+	for p, n := range counts {
+		if n < 20000/len(counts)/2 || n > 20000/len(counts)*2 {
+			t.Fatalf("pixel %v has %v of 20000 points", p, n)
+		}
+	}
+	// ring scheme
+	for i, want := range []uint64{13, 5, 4, 0} {
+		if r := NestToRing(1, uint64(i)); r != want {
+			t.Fatalf("nested pixel %v of order 1 is ring pixel %v, expected %v", i, r, want)
+		}
+	}
+	for _, order := range []int{0, 1, 2, 3, 4, 29} {
+		npix := uint64(12) << (2 * order)
+		step := 1 + npix/4999
+		for p := uint64(0); p < npix; p += step {
+			r := NestToRing(order, p)
+			if r >= npix || RingToNest(order, r) != p {
+				t.Fatalf("order %v nested pixel %v is ring pixel %v which is nested %v", order, p, r, RingToNest(order, r))
+			}
+		}
+		if order > 4 {
+			continue
+		}
+		// rings run south, each eastward from longitude zero
+		var z, phi float64
+		for r := uint64(0); r < npix; r++ {
+			v := HEALPixCenter(order, RingToNest(order, r))
+			lon, _ := LonLat(v)
+			lon = math.Mod(lon+360, 360)
+			if r > 0 && (v.Z > z+1e-12 || equal(v.Z, z) && lon <= phi) {
+				t.Fatalf("order %v ring pixel %v at %v, %v follows %v, %v", order, r, v.Z, lon, z, phi)
+			}
+			if HEALPixRing(order, v) != r {
+				t.Fatalf("order %v center of ring pixel %v is in %v", order, r, HEALPixRing(order, v))
+			}
+			z, phi = v.Z, lon
+		}
+	}
+}
+
+func TestTrixelPixels(t *testing.T) {
+	h := New()
+	h.SubDivide(5)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		v, _ := Vec3{r.NormFloat64(), r.NormFloat64(), r.NormFloat64()}.Normalized()
+		tr, err := h.LookupByCart(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, order := range []int{2, 6} {
+			p := HEALPixNest(order, v)
+			if !slices.Contains(h.TrixelPixels(tr.Index, order), p) {
+				t.Fatalf("%v in trixel %v and pixel %v of order %v", v, tr.Index, p, order)
+			}
+			if !slices.Contains(h.PixelTrixels(order, p), tr.Index) {
+				t.Fatalf("%v in pixel %v of order %v and trixel %v", v, p, order, tr.Index)
+			}
+		}
+	}
+	// a small trixel overlaps few small pixels, and a large pixel many small trixels
+	leaf := 0
+	for !h.Trees[leaf].Leaf() {
+		leaf = h.Trees[leaf].Children[3]
+	}
+	if n := len(h.TrixelPixels(leaf, 4)); n == 0 || n > 16 {
+		t.Fatalf("leaf overlaps %v pixels", n)
+	}
+	if n := len(h.PixelTrixels(0, 4)); n < 4096/12 {
+		t.Fatalf("base pixel overlaps %v leaves", n)
+	}
+}
+
+func TestMOC(t *testing.T) {
+	m := MOC{1: {0, 1, 2, 3, 9}, 2: {36, 37, 38, 39, 40}, 3: {160, 161}}
+	m.Normalize()
+	if want := (MOC{0: {0}, 1: {9}, 2: {40}}); !reflect.DeepEqual(m, want) {
+		t.Fatalf("expected %v but have %v", want, m)
+	}
+
+	text, err := (MOC{1: {0, 1, 2, 8}, 3: {700}}).MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "1/0-2 8 3/700" {
+		t.Fatalf("unexpected text %q", text)
+	}
+	var x MOC
+	if err := x.UnmarshalText([]byte("1/0-2,8 2/ 3/700 4/")); err != nil {
+		t.Fatal(err)
+	}
+	if want := (MOC{1: {0, 1, 2, 8}, 3: {700}}); !reflect.DeepEqual(x, want) {
+		t.Fatalf("expected %v but have %v", want, x)
+	}
+	js, err := json.Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `{"1":[0,1,2,8],"3":[700]}` {
+		t.Fatalf("unexpected JSON %s", js)
+	}
+	var y MOC
+	if err := json.Unmarshal(js, &y); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x, y) {
+		t.Fatalf("expected %v but have %v", x, y)
+	}
+	for _, src := range []string{"30/1", "0/12", "1", "1/3-2", "x/1"} {
+		if err := x.UnmarshalText([]byte(src)); err == nil {
+			t.Fatalf("expected error decoding %q", src)
+		}
+	}
+	for _, src := range []string{`{"0":[12]}`, `{"a":[1]}`, `{"-1":[0]}`} {
+		if err := json.Unmarshal([]byte(src), &x); err == nil {
+			t.Fatalf("expected error decoding %s", src)
+		}
+	}
+
+	h := New()
+	h.SubDivide(6)
+	p, _ := Vec3{0.3, 0.2, 0.9}.Normalized()
+	cn := &Constraint{p, 0.9}
+	cover := h.Intersections(cn)
+	covered := make(map[int]bool)
+	for _, idx := range cover {
+		covered[idx] = true
+	}
+	inCover := func(h *HTM, covered map[int]bool, v Vec3) bool {
+		tr, err := h.LookupByCart(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for idx := tr.Index; idx != -1; idx = h.Trees[idx].Parent {
+			if covered[idx] {
+				return true
+			}
+		}
+		return false
+	}
+
+	outer := h.MOC(cover, 8, Conservative)
+	inner := h.MOC(cover, 8, Inner)
+	if len(inner) == 0 {
+		t.Fatal("empty inner MOC")
+	}
+	r := rand.New(rand.NewSource(1))
+	var n [3]int
+	for i := 0; i < 20000; i++ {
+		v, _ := Vec3{r.NormFloat64(), r.NormFloat64(), r.NormFloat64() + 3}.Normalized()
+		in := inCover(h, covered, v)
+		if in && !outer.Contains(v) {
+			t.Fatalf("%v in cover but not conservative MOC", v)
+		}
+		if inner.Contains(v) && !in {
+			t.Fatalf("%v in inner MOC but not cover", v)
+		}
+		if in {
+			n[0]++
+		}
+		if inner.Contains(v) {
+			n[1]++
+		}
+		if outer.Contains(v) {
+			n[2]++
+		}
+	}
+	if n[1] < n[0]*8/10 || n[2] > n[0]*12/10 {
+		t.Fatalf("inner, cover and conservative contain %v, %v and %v points", n[1], n[0], n[2])
+	}
+
+	// and back
+	h = New()
+	h.SubDivide(7)
+	for _, mode := range []CoverMode{Conservative, Inner} {
+		cover := h.CoverMOC(inner, mode)
+		covered := make(map[int]bool)
+		for _, idx := range cover {
+			covered[idx] = true
+		}
+		for i := 0; i < 5000; i++ {
+			v, _ := Vec3{r.NormFloat64(), r.NormFloat64(), r.NormFloat64() + 3}.Normalized()
+			in := inCover(h, covered, v)
+			if mode == Conservative && inner.Contains(v) && !in {
+				t.Fatalf("%v in MOC but not conservative cover", v)
+			}
+			if mode == Inner && in && !inner.Contains(v) {
+				t.Fatalf("%v in inner cover but not MOC", v)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	h := New()
+	if len(h.Trees) != 8 {
+		t.Fatal("Trees not initialized correctly.")
+	}
+	if len(h.Vertices) == 0 {
+		t.Fatal("HTM vertices not initialized.")
+	}
+}
+
+func TestNewIcosahedron(t *testing.T) {
+	h := NewIcosahedron()
+	if h.Roots() != 20 {
+		t.Fatalf("expected 20 roots but have %v", h.Roots())
+	}
+	h.SubDivide(3)
+	if len(h.Vertices) != 162 {
+		t.Fatalf("expected 162 vertices but have %v", len(h.Vertices))
+	}
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range h.Vertices {
+		if !equal(v.Length(), 1) {
+			t.Fatalf("vertex %v not on unit sphere", v)
+		}
+	}
+	tr, err := h.LookupByCart(Vec3{0.3, -0.2, 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Level != 3 {
+		t.Fatalf("expected level 3 but have %v", tr.Level)
+	}
+}
+
+func TestNewMeshFlat(t *testing.T) {
+	// bipyramid whose apexes have eight lower indexed neighbors
+	var vertices []Vec3
+	var faces [][3]int
+	for k := 0; k < 8; k++ {
+		a := float64(k) * math.Pi / 4
+		vertices = append(vertices, Vec3{math.Cos(a), math.Sin(a), 0})
+		faces = append(faces, [3]int{k, (k + 1) % 8, 8}, [3]int{(k + 1) % 8, k, 9})
+	}
+	vertices = append(vertices, Vec3{0, 0, 1}, Vec3{0, 0, -1})
+
+	h, err := NewMesh(vertices, faces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Midpoint = FlatMidpoint
+	h.SubDivide(3)
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+
+	// midpoints lie on the faces rather than the unit sphere
+	mid := h.Edges.mid(0, 1)
+	if mid == -1 || !h.Vertices[mid].Equals(vertices[0].Add(vertices[1]).MulScalar(0.5)) {
+		t.Fatalf("expected flat midpoint between vertices 0 and 1, have %v", mid)
+	}
+	tr, err := h.LookupByCart(Vec3{1, 0.4, 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Level != 3 {
+		t.Fatalf("expected level 3 but have %v", tr.Level)
+	}
+
+	if _, err := NewMesh(vertices, faces[1:]); err == nil {
+		t.Fatal("expected error for open mesh")
+	}
+}
+
+type set struct {
+	Data []float64
+}
+
+func (s *set) put(x float64) {
+	x = math.Abs(x)
+	for _, v := range s.Data {
+		if equal(v, x) {
+			return
+		}
+	}
+	s.Data = append(s.Data, x)
+}
+
+func testUnique(t *testing.T) {
+	h := New()
+	h.SubDivide(8)
+	s := &set{}
+	for _, v := range h.Vertices {
+		s.put(v.X)
+		s.put(v.Y)
+		s.put(v.Z)
+	}
+	sort.Float64s(s.Data)
+	// t.Log("Unique")
+	// for _, v := range s.Data {
+	// 	t.Logf("%v", v)
+	// }
+	t.Logf("Unique: %v\n", len(s.Data))
+}
+
+func TestSubDivide2(t *testing.T) {
+	h := New()
+	h.SubDivide(2)
+	if len(h.Vertices) != 18 {
+		t.Fatalf("Expected 18 vertices but got %v.", len(h.Vertices))
+	}
+
+	cmp := func(a float64, b string) bool { return fmt.Sprintf("%.3f", a) == b }
+	check := func(msg string, expects [3]string, v Vec3) {
+		if !cmp(v.X, expects[0]) {
+			t.Fatal(msg, "failed for x, expected", expects[0], "but have", v.X)
+		}
+		if !cmp(v.Y, expects[1]) {
+			t.Fatal(msg, "failed for y, expected", expects[1], "but have", v.Y)
+		}
+		if !cmp(v.Z, expects[2]) {
+			t.Fatal(msg, "failed for z, expected", expects[2], "but have", v.Z)
+		}
+	}
+
+	check("first subdivision", [3]string{"0.000", "0.707", "-0.707"}, h.Vertices[6])
+	check("second subdivision", [3]string{"0.707", "0.707", "0.000"}, h.Vertices[7])
+	check("third subdivision", [3]string{"0.707", "0.000", "-0.707"}, h.Vertices[8])
+
+	if err := validateHTM(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTexCoords(t *testing.T) {
+	h := New()
+	h.SubDivide(2)
+	tc := h.TexCoords()
+	if (len(tc) % 2) != 0 {
+		t.Fatal("Uneven UV mapping.")
+	}
+}
+
+func TestLookupByCart(t *testing.T) {
+	h := New()
+	h.SubDivide(7)
+	_, err := h.LookupByCart(Vec3{0.9, 0.1, 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkL5(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivide(5)
+	}
+}
+
+func BenchmarkL7(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivide(7)
+	}
+}
+
+func BenchmarkL9(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivide(9)
+	}
+}
+
+func BenchmarkL11(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivide(11)
+	}
+}
+
+func BenchmarkParallelL9(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivideParallel(9)
+	}
+}
+
+func BenchmarkParallelL11(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		h := New()
+		h.SubDivideParallel(11)
+	}
+}
+
+// sizeHTM returns the bytes held by the slices of h.
+func sizeHTM(h *HTM) int {
+	n := cap(h.Trees)*int(unsafe.Sizeof(Tree{})) + cap(h.Vertices)*int(unsafe.Sizeof(Vec3{}))
+	n += cap(h.Edges.slice)*int(unsafe.Sizeof(Edge{})) + cap(h.refs)*8
+	for _, o := range h.Edges.overflow {
+		n += cap(o) * int(unsafe.Sizeof(Edge{}))
+	}
+	return n
+}
+
+// sizePacked returns the bytes held by the slices of p.
+func sizePacked(p *Packed) int {
+	n := (len(p.Starts) + len(p.Indices) + len(p.Children)) * 4
+	return n + len(p.Vertices)*int(unsafe.Sizeof(Vec3{})) + len(p.Vertices32)*int(unsafe.Sizeof(Vec3f{}))
+}
+
+func TestMemory(t *testing.T) {
+	for _, lvl := range []int{5, 7, 9} {
+		h := New()
+		h.SubDivide(lvl)
+		ptr, packed, packed32 := sizeHTM(h), sizePacked(Pack(h)), sizePacked(PackFloat32(h))
+		t.Logf("L%v: HTM %v bytes, Packed %v bytes, Packed32 %v bytes", lvl, ptr, packed, packed32)
+		if packed32 >= packed || packed >= ptr {
+			t.Fatalf("L%v: expected packed layouts to be smaller", lvl)
+		}
 	}
 }
 
@@ -623,7 +2756,7 @@ func BenchmarkLookupByCartL7(b *testing.B) {
 	h.SubDivide(7)
 	b.StartTimer()
 	for n := 0; n < b.N; n++ {
-		_, err := h.LookupByCart(lmath.Vec3{0.9, 0.1, 0.1})
+		_, err := h.LookupByCart(Vec3{0.9, 0.1, 0.1})
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -634,7 +2767,7 @@ func BenchmarkConstraintIterL7(b *testing.B) {
 	b.StopTimer()
 	h := New()
 	h.SubDivide(7)
-	cn := &Constraint{lmath.Vec3{0, 0, 1}, 0.5}
+	cn := &Constraint{Vec3{0, 0, 1}, 0.5}
 	b.StartTimer()
 	for n := 0; n < b.N; n++ {
 		for _, t := range h.Intersections(cn) {
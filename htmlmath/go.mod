@@ -0,0 +1,10 @@
+module dasa.cc/htm/htmlmath
+
+go 1.23.1
+
+require (
+	dasa.cc/htm v0.0.0-00010101000000-000000000000
+	github.com/azul3d/engine v0.0.0-20211024043305-793ea6c2839d
+)
+
+replace dasa.cc/htm => ../
@@ -0,0 +1,54 @@
+// Package htmlmath adapts the vectors of package htm to and from those of azul3d's lmath.
+package htmlmath
+
+import (
+	"dasa.cc/htm"
+	"github.com/azul3d/engine/lmath"
+)
+
+// Vec3 converts v to an lmath vector.
+func Vec3(v htm.Vec3) lmath.Vec3 {
+	return lmath.Vec3{X: v.X, Y: v.Y, Z: v.Z}
+}
+
+// FromVec3 converts an lmath vector to v.
+func FromVec3(v lmath.Vec3) htm.Vec3 {
+	return htm.Vec3{X: v.X, Y: v.Y, Z: v.Z}
+}
+
+// Vec3s converts vs to lmath vectors, such as the Vertices or Positions of an HTM.
+func Vec3s(vs []htm.Vec3) []lmath.Vec3 {
+	ls := make([]lmath.Vec3, len(vs))
+	for i, v := range vs {
+		ls[i] = Vec3(v)
+	}
+	return ls
+}
+
+// FromVec3s converts lmath vectors, such as the vertices of a mesh for htm.NewMesh.
+func FromVec3s(ls []lmath.Vec3) []htm.Vec3 {
+	vs := make([]htm.Vec3, len(ls))
+	for i, v := range ls {
+		vs[i] = FromVec3(v)
+	}
+	return vs
+}
+
+// Midpoint adapts a midpoint rule written for lmath vectors.
+func Midpoint(f func(v0, v1 lmath.Vec3) lmath.Vec3) htm.MidpointFunc {
+	return func(v0, v1 htm.Vec3) htm.Vec3 {
+		return FromVec3(f(Vec3(v0), Vec3(v1)))
+	}
+}
+
+// Surface adapts a surface mapping written for lmath vectors.
+type Surface func(v lmath.Vec3) lmath.Vec3
+
+func (s Surface) Position(v htm.Vec3) htm.Vec3 {
+	return FromVec3(s(Vec3(v)))
+}
+
+// Constraint converts a circular area given by an lmath plane normal and distance.
+func Constraint(p lmath.Vec3, d float64) *htm.Constraint {
+	return &htm.Constraint{P: FromVec3(p), D: d}
+}
@@ -0,0 +1,34 @@
+package htmlmath
+
+import (
+	"testing"
+
+	"dasa.cc/htm"
+	"github.com/azul3d/engine/lmath"
+)
+
+func TestRoundTrip(t *testing.T) {
+	h := htm.New()
+	h.Midpoint = Midpoint(func(v0, v1 lmath.Vec3) lmath.Vec3 {
+		w, _ := v0.Add(v1).Normalized()
+		return w
+	})
+	h.Surface = Surface(func(v lmath.Vec3) lmath.Vec3 { return v.MulScalar(2) })
+	h.SubDivide(3)
+
+	a := htm.New()
+	a.SubDivide(3)
+	for i, v := range FromVec3s(Vec3s(h.Vertices)) {
+		if v != a.Vertices[i] {
+			t.Fatalf("vertex %v differs: %v, %v", i, v, a.Vertices[i])
+		}
+		if p := h.PositionAt(i); !p.Equals(v.MulScalar(2)) {
+			t.Fatalf("position %v not scaled: %v", i, p)
+		}
+	}
+
+	mt := h.Intersections(Constraint(lmath.Vec3{X: 0.001, Y: 0.001, Z: 0.999}, 0.85))
+	if len(mt) == 0 {
+		t.Fatal("expected intersections")
+	}
+}
@@ -0,0 +1,10 @@
+module dasa.cc/htm/htms2
+
+go 1.23.1
+
+require (
+	dasa.cc/htm v0.0.0-00010101000000-000000000000
+	github.com/golang/geo v0.0.0-20230421003525-6adc56603217
+)
+
+replace dasa.cc/htm => ../
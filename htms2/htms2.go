@@ -0,0 +1,167 @@
+// Package htms2 adapts the regions and covers of package htm to and from those of the S2 geometry
+// library, github.com/golang/geo/s2.
+package htms2
+
+import (
+	"math"
+
+	"dasa.cc/htm"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// Point converts v to a unit length s2 point.
+func Point(v htm.Vec3) s2.Point {
+	return s2.PointFromCoords(v.X, v.Y, v.Z)
+}
+
+// FromPoint converts an s2 point to v.
+func FromPoint(p s2.Point) htm.Vec3 {
+	return htm.Vec3{X: p.X, Y: p.Y, Z: p.Z}
+}
+
+// Cap converts the circular area of c to a cap. Constraints slicing nothing off the sphere, or all of
+// it, give the empty or full cap.
+func Cap(c *htm.Constraint) s2.Cap {
+	n := c.P.Length()
+	switch {
+	case n == 0 && c.D < 0:
+		return s2.FullCap()
+	case n == 0:
+		return s2.EmptyCap()
+	}
+	d := c.D / n
+	switch {
+	case d >= 1:
+		return s2.EmptyCap()
+	case d <= -1:
+		return s2.FullCap()
+	}
+	return s2.CapFromCenterAngle(Point(c.P), s1.Angle(math.Acos(d)))
+}
+
+// FromCap converts a cap to a constraint of unit normal.
+func FromCap(c s2.Cap) *htm.Constraint {
+	switch {
+	case c.IsEmpty():
+		return &htm.Constraint{P: htm.Vec3{Z: 1}, D: 2}
+	case c.IsFull():
+		return &htm.Constraint{P: htm.Vec3{Z: 1}, D: -2}
+	}
+	return &htm.Constraint{P: FromPoint(c.Center()), D: 1 - c.Height()}
+}
+
+// Loop converts p to a loop. Both keep the interior on the left of their edges.
+func Loop(p htm.Polygon) *s2.Loop {
+	pts := make([]s2.Point, len(p))
+	for i, v := range p {
+		pts[i] = Point(v)
+	}
+	return s2.LoopFromPoints(pts)
+}
+
+// FromLoop converts the vertices of a loop, which must be neither empty nor full, to a polygon.
+func FromLoop(l *s2.Loop) htm.Polygon {
+	p := make(htm.Polygon, l.NumVertices())
+	for i, v := range l.Vertices() {
+		p[i] = FromPoint(v)
+	}
+	return p
+}
+
+// Polygon converts the region of c to a polygon with a loop for each ring of its Boundary. Circle
+// arcs of the boundary become chords of at most a degree, which s2 loops require.
+func Polygon(c htm.Convex) *s2.Polygon {
+	rings := c.Boundary()
+	if len(rings) == 0 {
+		// without a boundary c is either everything or nothing
+		if v := (htm.Vec3{Z: 1}); c.Test(v, v, v) == htm.Inside {
+			return s2.FullPolygon()
+		}
+		return s2.PolygonFromLoops(nil)
+	}
+	loops := make([]*s2.Loop, len(rings))
+	for i, ring := range rings {
+		loops[i] = Loop(ring)
+	}
+	return s2.PolygonFromOrientedLoops(loops)
+}
+
+// intersects reports whether cell intersects the triangle v0, v1, v2 with great circle edges. Both
+// are convex, so they intersect if a vertex of either is inside the other or their edges cross.
+func intersects(cell s2.Cell, v0, v1, v2 htm.Vec3) bool {
+	tri := [3]s2.Point{Point(v0), Point(v1), Point(v2)}
+	for _, p := range tri {
+		if cell.ContainsPoint(p) {
+			return true
+		}
+	}
+	n0, n1, n2 := v0.Cross(v1), v1.Cross(v2), v2.Cross(v0)
+	for k := 0; k < 4; k++ {
+		v := FromPoint(cell.Vertex(k))
+		if v.Dot(n0) >= 0 && v.Dot(n1) >= 0 && v.Dot(n2) >= 0 {
+			return true
+		}
+	}
+	for k := 0; k < 4; k++ {
+		a, b := cell.Vertex(k), cell.Vertex((k+1)%4)
+		for j := 0; j < 3; j++ {
+			if s2.CrossingSign(a, b, tri[j], tri[(j+1)%3]) != s2.DoNotCross {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cells returns the hierarchy of cells down to level.
+func cells(level int) htm.Hierarchy[s2.Cell] {
+	x := htm.Hierarchy[s2.Cell]{
+		Children: func(c s2.Cell) []s2.Cell {
+			if c.Level() >= level {
+				return nil
+			}
+			ids := c.ID().Children()
+			cs := make([]s2.Cell, len(ids))
+			for k, id := range ids {
+				cs[k] = s2.CellFromCellID(id)
+			}
+			return cs
+		},
+		Intersects: intersects,
+	}
+	for f := 0; f < 6; f++ {
+		x.Roots = append(x.Roots, s2.CellFromCellID(s2.CellIDFromFace(f)))
+	}
+	return x
+}
+
+// CellUnion returns the region of the nodes of h at the given indices as a normalized union of
+// cells up to level, as with htm.RegionCells.
+func CellUnion(h *htm.HTM, cover []int, level int, mode htm.CoverMode) s2.CellUnion {
+	if level > s2.MaxLevel {
+		level = s2.MaxLevel
+	}
+	var cu s2.CellUnion
+	for _, c := range htm.RegionCells(h, cover, cells(level), mode) {
+		cu = append(cu, c.ID())
+	}
+	cu.Normalize()
+	return cu
+}
+
+// Cover returns the nodes of h in the region of cu as with htm.RegionCover.
+func Cover(h *htm.HTM, cu s2.CellUnion, mode htm.CoverMode) []int {
+	cu = append(s2.CellUnion(nil), cu...)
+	cu.Normalize()
+	status := func(c s2.Cell) htm.Coverage {
+		switch {
+		case cu.ContainsCellID(c.ID()):
+			return htm.Inside
+		case cu.IntersectsCellID(c.ID()):
+			return htm.Partial
+		}
+		return htm.Outside
+	}
+	return htm.RegionCover(h, cells(s2.MaxLevel), status, mode)
+}
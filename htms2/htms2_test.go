@@ -0,0 +1,269 @@
+package htms2
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"dasa.cc/htm"
+	"github.com/golang/geo/s2"
+)
+
+// randVec returns a random unit direction.
+func randVec(r *rand.Rand) htm.Vec3 {
+	v, _ := htm.Vec3{X: r.NormFloat64(), Y: r.NormFloat64(), Z: r.NormFloat64()}.Normalized()
+	return v
+}
+
+// randCap returns a constraint of random center and an angular radius from 0.1 to 1.5.
+func randCap(r *rand.Rand) *htm.Constraint {
+	return &htm.Constraint{P: randVec(r), D: math.Cos(0.1 + 1.4*r.Float64())}
+}
+
+// randPolygon returns a star shaped polygon of n vertices around a random center, each within
+// 0.2 to 0.6 of it.
+func randPolygon(r *rand.Rand, n int) htm.Polygon {
+	c := randVec(r)
+	u, _ := c.Cross(htm.Vec3{X: 1}).Normalized()
+	if u.Length() < 0.5 {
+		u, _ = c.Cross(htm.Vec3{Y: 1}).Normalized()
+	}
+	w := c.Cross(u)
+	p := make(htm.Polygon, n)
+	for i := range p {
+		phi := 2 * math.Pi * (float64(i) + 0.8*r.Float64()) / float64(n)
+		theta := 0.2 + 0.4*r.Float64()
+		dir := u.MulScalar(math.Cos(phi)).Add(w.MulScalar(math.Sin(phi)))
+		p[i] = c.MulScalar(math.Cos(theta)).Add(dir.MulScalar(math.Sin(theta)))
+	}
+	return p
+}
+
+// triangleArea returns the area of the spherical triangle v0, v1, v2.
+func triangleArea(v0, v1, v2 htm.Vec3) float64 {
+	return 2 * math.Abs(math.Atan2(v0.Dot(v1.Cross(v2)), 1+v0.Dot(v1)+v1.Dot(v2)+v2.Dot(v0)))
+}
+
+// area estimates the area of a region from the leaves of h whose centers are inside it.
+func area(h *htm.HTM, inside func(v htm.Vec3) bool) float64 {
+	a := 0.0
+	for idx := range h.Leaves() {
+		v0, v1, v2 := h.VerticesAt(idx)
+		if c, _ := v0.Add(v1).Add(v2).Normalized(); inside(c) {
+			a += triangleArea(v0, v1, v2)
+		}
+	}
+	return a
+}
+
+func contains(t htm.Tester, v htm.Vec3) bool {
+	return t.Test(v, v, v) == htm.Inside
+}
+
+func TestCap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		cn := randCap(r)
+		c := Cap(cn)
+		if want := 2 * math.Pi * (1 - cn.D); math.Abs(c.Area()-want) > 1e-9 {
+			t.Fatalf("%v: expected area %v but have %v", cn, want, c.Area())
+		}
+		for k := 0; k < 200; k++ {
+			v := randVec(r)
+			if math.Abs(cn.P.Dot(v)-cn.D) < 1e-9 {
+				continue
+			}
+			if in := contains(cn, v); in != c.ContainsPoint(Point(v)) {
+				t.Fatalf("%v: containment of %v differs, htm %v", cn, v, in)
+			}
+		}
+		back := FromCap(c)
+		if !back.P.Equals(cn.P) || math.Abs(back.D-cn.D) > 1e-9 {
+			t.Fatalf("expected %v but have %v", cn, back)
+		}
+	}
+
+	v := htm.Vec3{X: 1}
+	if c := Cap(&htm.Constraint{P: v, D: 1}); !c.IsEmpty() {
+		t.Fatalf("expected empty cap but have %v", c)
+	}
+	if c := Cap(&htm.Constraint{P: v, D: -1}); !c.IsFull() {
+		t.Fatalf("expected full cap but have %v", c)
+	}
+	if cn := FromCap(s2.EmptyCap()); contains(cn, v) {
+		t.Fatalf("empty cap contains %v", v)
+	}
+	if cn := FromCap(s2.FullCap()); !contains(cn, v.MulScalar(-1)) {
+		t.Fatal("full cap does not contain the antipode")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	h := htm.New()
+	h.SubDivide(7)
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		p := randPolygon(r, 3+r.Intn(8))
+		d, err := p.Domain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := Loop(p)
+		if err := l.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if a, b := l.Area(), area(h, func(v htm.Vec3) bool { return contains(d, v) }); math.Abs(a-b) > 0.03*a {
+			t.Fatalf("polygon %v: s2 area %v differs from htm area %v", i, a, b)
+		}
+		for k := 0; k < 500; k++ {
+			v := randVec(r)
+			near := false
+			for j := range p {
+				n, _ := p[j].Cross(p[(j+1)%len(p)]).Normalized()
+				if math.Abs(n.Dot(v)) < 1e-9 {
+					near = true
+				}
+			}
+			if near {
+				continue
+			}
+			if in := contains(d, v); in != l.ContainsPoint(Point(v)) {
+				t.Fatalf("polygon %v: containment of %v differs, htm %v", i, v, in)
+			}
+		}
+		back := FromLoop(l)
+		if len(back) != len(p) {
+			t.Fatalf("expected %v vertices but have %v", len(p), len(back))
+		}
+		for j := range p {
+			if !back[j].Equals(p[j]) {
+				t.Fatalf("vertex %v: expected %v but have %v", j, p[j], back[j])
+			}
+		}
+	}
+}
+
+func TestPolygon(t *testing.T) {
+	h := htm.New()
+	h.SubDivide(7)
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		// caps around nearby centers, so their intersection is rarely empty
+		center := randVec(r)
+		var c htm.Convex
+		for k := 0; k < 1+r.Intn(3); k++ {
+			p, _ := center.Add(randVec(r).MulScalar(0.4)).Normalized()
+			c = append(c, &htm.Constraint{P: p, D: math.Cos(0.4 + r.Float64())})
+		}
+		poly := Polygon(c)
+		want := area(h, func(v htm.Vec3) bool { return contains(c, v) })
+		if a := poly.Area(); math.Abs(a-want) > 0.03*want+1e-3 {
+			t.Fatalf("convex %v: s2 area %v differs from htm area %v", i, a, want)
+		}
+		for k := 0; k < 500; k++ {
+			v := randVec(r)
+			near := false
+			for _, cn := range c {
+				// chords of the boundary stray from the circles by up to 4e-5
+				if math.Abs(cn.P.Dot(v)-cn.D) < 1e-4 {
+					near = true
+				}
+			}
+			if near {
+				continue
+			}
+			if in := contains(c, v); in != poly.ContainsPoint(Point(v)) {
+				t.Fatalf("convex %v: containment of %v differs, htm %v", i, v, in)
+			}
+		}
+	}
+
+	if p := Polygon(htm.Convex{}); !p.IsFull() {
+		t.Fatal("expected full polygon of no constraints")
+	}
+	v := htm.Vec3{Z: 1}
+	if p := Polygon(htm.Convex{{P: v, D: 0.5}, {P: v.MulScalar(-1), D: 0.5}}); !p.IsEmpty() {
+		t.Fatal("expected empty polygon of disjoint constraints")
+	}
+}
+
+func TestCellUnion(t *testing.T) {
+	h := htm.New()
+	h.SubDivide(5)
+	r := rand.New(rand.NewSource(4))
+
+	// inCover reports whether the leaf containing v is within a node of the cover
+	inCover := func(covered map[int]bool, v htm.Vec3) bool {
+		tr, err := h.LookupByCart(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for idx := tr.Index; idx != -1; idx = h.Trees[idx].Parent {
+			if covered[idx] {
+				return true
+			}
+		}
+		return false
+	}
+	coverArea := func(cover []int) float64 {
+		a := 0.0
+		for _, idx := range cover {
+			a += triangleArea(h.VerticesAt(idx))
+		}
+		return a
+	}
+
+	for i := 0; i < 5; i++ {
+		cn := randCap(r)
+		cover := h.Intersections(cn)
+		covered := make(map[int]bool)
+		for _, idx := range cover {
+			covered[idx] = true
+		}
+		outer := CellUnion(h, cover, 9, htm.Conservative)
+		inner := CellUnion(h, cover, 9, htm.Inner)
+		a := coverArea(cover)
+		if outer.ExactArea() < a || inner.ExactArea() > a || inner.ExactArea() < 0.8*a {
+			t.Fatalf("cap %v: cover area %v not within cell unions %v, %v", i, a, inner.ExactArea(), outer.ExactArea())
+		}
+		for k := 0; k < 2000; k++ {
+			v := randVec(r)
+			in := inCover(covered, v)
+			if in && !outer.ContainsPoint(Point(v)) {
+				t.Fatalf("cap %v: %v in cover but not conservative cell union", i, v)
+			}
+			if !in && inner.ContainsPoint(Point(v)) {
+				t.Fatalf("cap %v: %v in inner cell union but not cover", i, v)
+			}
+		}
+
+		// back to trixels from the cell union of the cap
+		rc := &s2.RegionCoverer{MaxLevel: 12, MaxCells: 40}
+		cu := rc.Covering(Cap(cn))
+		outerCover := Cover(h, cu, htm.Conservative)
+		innerCover := Cover(h, cu, htm.Inner)
+		if b := coverArea(outerCover); b < cu.ExactArea() {
+			t.Fatalf("cap %v: conservative cover area %v less than cell union %v", i, b, cu.ExactArea())
+		}
+		if b := coverArea(innerCover); b > cu.ExactArea() {
+			t.Fatalf("cap %v: inner cover area %v more than cell union %v", i, b, cu.ExactArea())
+		}
+		outerCovered, innerCovered := make(map[int]bool), make(map[int]bool)
+		for _, idx := range outerCover {
+			outerCovered[idx] = true
+		}
+		for _, idx := range innerCover {
+			innerCovered[idx] = true
+		}
+		for k := 0; k < 2000; k++ {
+			v := randVec(r)
+			in := cu.ContainsPoint(Point(v))
+			if in && !inCover(outerCovered, v) {
+				t.Fatalf("cap %v: %v in cell union but not conservative cover", i, v)
+			}
+			if !in && inCover(innerCovered, v) {
+				t.Fatalf("cap %v: %v in inner cover but not cell union", i, v)
+			}
+		}
+	}
+}
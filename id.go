@@ -0,0 +1,52 @@
+package htm
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// rootBits returns the number of bits needed to number the root nodes of h.
+func (h *HTM) rootBits() int {
+	return bits.Len(uint(h.roots - 1))
+}
+
+// ID returns the trixel ID of the node at idx. The root node r is 1<<b|r with b the bits needed to
+// number the roots, so the octahedron's roots S0 through N3 are 8 through 15 as in the SDSS HTM,
+// and the child k of a node, in the order of Tree.Children, is its parent's ID<<2|k. IDs are
+// unique for up to 64 bits, such as 31 levels of the octahedron.
+func (h *HTM) ID(idx int) uint64 {
+	var id uint64
+	shift := 0
+	for t := h.Trees[idx]; t.Parent != -1; t = h.Trees[t.Parent] {
+		p := h.Trees[t.Parent]
+		for k, c := range p.Children {
+			if c == t.Index {
+				id |= uint64(k) << shift
+			}
+		}
+		shift += 2
+		idx = t.Parent
+	}
+	return id | (1<<h.rootBits()|uint64(idx))<<shift
+}
+
+// Trixel returns the index of the node with the given trixel ID.
+func (h *HTM) Trixel(id uint64) (int, error) {
+	b := h.rootBits()
+	n := bits.Len64(id) - 1 - b
+	if n < 0 || n%2 != 0 {
+		return -1, fmt.Errorf("invalid trixel ID %v", id)
+	}
+	idx := int(id>>n) &^ (1 << b)
+	if idx >= h.roots {
+		return -1, fmt.Errorf("invalid trixel ID %v", id)
+	}
+	for n -= 2; n >= 0; n -= 2 {
+		t := h.Trees[idx]
+		if t.Leaf() {
+			return -1, fmt.Errorf("trixel ID %v below leaf %v", id, idx)
+		}
+		idx = t.Children[(id>>n)&3]
+	}
+	return idx, nil
+}
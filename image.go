@@ -9,8 +9,6 @@ import (
 	"math"
 	"os"
 	"sort"
-
-	"github.com/azul3d/engine/lmath"
 )
 
 // max returns largest value contained in vertices.
@@ -59,7 +57,7 @@ func norm(x float64, max float64) float64 {
 	return (x + max) / (max * 2)
 }
 
-type vec3Slice []lmath.Vec3
+type vec3Slice []Vec3
 
 func (p vec3Slice) Len() int           { return len(p) }
 func (p vec3Slice) Less(i, j int) bool { return p[i].Z < p[j].Z }
@@ -75,7 +73,7 @@ func Image(h *HTM, size image.Point, cset ColorSet) *image.RGBA {
 	m := image.NewRGBA(r)
 
 	max := maxAbs(h)
-	p := append([]lmath.Vec3(nil), h.VerticesNotEmpty()...)
+	p := append([]Vec3(nil), h.VerticesNotEmpty()...)
 	sortVec3s(p)
 	for _, v0 := range p {
 		x := int(norm(v0.X, max) * float64(size.X))
@@ -92,8 +90,8 @@ func ImageConstraint(h *HTM, cn Tester, size image.Point, cset ColorSet) *image.
 	m := image.NewRGBA(r)
 
 	max := maxAbs(h)
-	var p []lmath.Vec3
-	for idx := range Iter(h, h.Intersections(cn)...) {
+	var p []Vec3
+	for idx := range Leaves(h, h.Intersections(cn)...) {
 		v0, v1, v2 := h.VerticesAt(idx)
 		p = append(p, v0, v1, v2)
 	}
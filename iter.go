@@ -0,0 +1,206 @@
+package htm
+
+import "iter"
+
+// Leaves returns an iterator over the leaves at or below each of the given nodes, depth first.
+func Leaves(h *HTM, positions ...int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for _, pos := range positions {
+			if !leaves(h, pos, yield) {
+				return
+			}
+		}
+	}
+}
+
+func leaves(h *HTM, idx int, yield func(int) bool) bool {
+	t := h.Trees[idx]
+	if t.Leaf() {
+		return yield(idx)
+	}
+	for _, c := range t.Children {
+		if !leaves(h, c, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaves returns an iterator over all leaves, depth first from each root node.
+func (h *HTM) Leaves() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for idx := 0; idx < h.roots; idx++ {
+			if !leaves(h, idx, yield) {
+				return
+			}
+		}
+	}
+}
+
+// AtLevel returns an iterator over the nodes at or below idx with the given level. Nodes below may
+// be modified during iteration, such as to cull or subdivide them.
+func AtLevel(h *HTM, idx int, lvl int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		atLevel(h, idx, lvl, yield)
+	}
+}
+
+func atLevel(h *HTM, idx int, lvl int, yield func(int) bool) bool {
+	t := h.Trees[idx]
+	if t.Level == lvl {
+		return yield(idx)
+	}
+	if t.Level > lvl || t.Leaf() {
+		return true
+	}
+	for _, c := range t.Children {
+		if !atLevel(h, c, lvl, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Ancestors returns an iterator over the parents of the node at idx, ending with its root node.
+func Ancestors(h *HTM, idx int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for p := h.Trees[idx].Parent; p != -1; p = h.Trees[p].Parent {
+			if !yield(p) {
+				return
+			}
+		}
+	}
+}
+
+// Intersecting returns an iterator over the same nodes as Intersections along with their coverage,
+// which is Partial only for leaves crossing the boundary of the test.
+func Intersecting(h *HTM, idx int, t Tester) iter.Seq2[int, Coverage] {
+	return func(yield func(int, Coverage) bool) {
+		intersecting(h, idx, t, yield)
+	}
+}
+
+func intersecting(h *HTM, idx int, t Tester, yield func(int, Coverage) bool) bool {
+	cv := t.Test(h.VerticesAt(idx))
+	if cv == Inside || (cv == Partial && h.Trees[idx].Leaf()) {
+		return yield(idx, cv)
+	}
+	if h.Trees[idx].Leaf() {
+		return true
+	}
+	for _, c := range h.Trees[idx].Children {
+		if !intersecting(h, c, t, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersecting returns an iterator over the nodes of Intersections along with their coverage.
+func (h *HTM) Intersecting(t Tester) iter.Seq2[int, Coverage] {
+	return func(yield func(int, Coverage) bool) {
+		for idx := 0; idx < h.roots; idx++ {
+			if !intersecting(h, idx, t, yield) {
+				return
+			}
+		}
+	}
+}
+
+// Neighbors returns an iterator over the leaves sharing part of an edge with the node at idx, in
+// the order of its edges opposite each of its vertices. A neighbor may be a coarser leaf or several
+// finer leaves along the edge.
+func Neighbors(h *HTM, idx int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		var seen []int
+		t := h.Trees[idx]
+		for k := range t.Indices {
+			u, v := t.Indices[(k+1)%3], t.Indices[(k+2)%3]
+			n := across(h, idx, u, v)
+			if n == -1 {
+				continue
+			}
+			ok := along(h, n, u, v, func(x int) bool {
+				for _, s := range seen {
+					if s == x {
+						return true
+					}
+				}
+				seen = append(seen, x)
+				return yield(x)
+			})
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// across returns the node on the other side of edge (u, v) of the node at idx, at the same level
+// or a coarser leaf if not subdivided as far, or -1 if there is none.
+func across(h *HTM, idx int, u, v int) int {
+	t := h.Trees[idx]
+	if t.Parent == -1 {
+		for r := 0; r < h.roots; r++ {
+			if r != idx && h.Trees[r].has(u) && h.Trees[r].has(v) {
+				return r
+			}
+		}
+		return -1
+	}
+
+	p := h.Trees[t.Parent]
+	for _, c := range p.Children {
+		if c != idx && h.Trees[c].has(u) && h.Trees[c].has(v) {
+			return c
+		}
+	}
+
+	// the edge lies on an edge of the parent, find the parent's neighbor across it
+	for k := range p.Indices {
+		x, y := p.Indices[(k+1)%3], p.Indices[(k+2)%3]
+		m := h.Edges.mid(x, y)
+		if (u != x && u != y && u != m) || (v != x && v != y && v != m) {
+			continue
+		}
+		q := across(h, t.Parent, x, y)
+		if q == -1 || h.Trees[q].Leaf() {
+			return q
+		}
+		for _, c := range h.Trees[q].Children {
+			if h.Trees[c].has(u) && h.Trees[c].has(v) {
+				return c
+			}
+		}
+		return q
+	}
+	return -1
+}
+
+// along calls yield for the leaves at or below the node at idx that share part of edge (u, v).
+func along(h *HTM, idx int, u, v int, yield func(int) bool) bool {
+	t := h.Trees[idx]
+	if t.Leaf() {
+		return yield(idx)
+	}
+	m := h.Edges.mid(u, v)
+	if m == -1 {
+		return yield(idx)
+	}
+	for _, seg := range [2][2]int{{u, m}, {m, v}} {
+		for _, c := range t.Children {
+			if h.Trees[c].has(seg[0]) && h.Trees[c].has(seg[1]) {
+				if !along(h, c, seg[0], seg[1], yield) {
+					return false
+				}
+				break
+			}
+		}
+	}
+	return true
+}
+
+// has reports if i is one of the indices of t.
+func (t Tree) has(i int) bool {
+	return t.Indices[0] == i || t.Indices[1] == i || t.Indices[2] == i
+}
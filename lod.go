@@ -0,0 +1,152 @@
+package htm
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// LOD manages view-dependent refinement of an HTM for a moving camera. Each call to Update
+// splits leaves whose screen-space error exceeds Threshold and merges nodes whose error has
+// fallen below it. A node changes by at most one level per call so work is spread across frames.
+type LOD struct {
+	HTM *HTM
+
+	// MinLevel and MaxLevel bound the level of every leaf.
+	MinLevel, MaxLevel int
+
+	// Threshold is the screen-space error above which a leaf is split. Error is the length of a
+	// node's longest edge divided by its distance from the camera, multiplied by Scale. Nodes facing
+	// away from the camera have no error.
+	Threshold float64
+
+	// Scale converts angular size to screen units, e.g. viewport height / (2*tan(fov/2)).
+	// Zero is treated as one.
+	Scale float64
+
+	// MaxTriangles limits the number of leaves. Zero is unlimited.
+	MaxTriangles int
+
+	// Budget limits the time spent splitting and merging in a single Update. Zero is unlimited.
+	Budget time.Duration
+}
+
+// LODStats reports the work done by a call to Update.
+type LODStats struct {
+	Splits, Merges int
+
+	// Triangles is the number of leaves after the update.
+	Triangles int
+
+	// Pending is true if the triangle or time budget stopped the update before all candidates
+	// were processed.
+	Pending bool
+}
+
+// NewLOD returns an LOD for h with the given level bounds and error threshold.
+func NewLOD(h *HTM, minLevel, maxLevel int, threshold float64) *LOD {
+	return &LOD{HTM: h, MinLevel: minLevel, MaxLevel: maxLevel, Threshold: threshold}
+}
+
+type lodNode struct {
+	idx int
+	err float64
+}
+
+// Update refines and coarsens the HTM for a camera at the given position. Merges are performed
+// first, lowest error first, followed by splits in order of highest error until the triangle
+// or time budget is reached.
+func (l *LOD) Update(cam Vec3) LODStats {
+	start := time.Now()
+	h := l.HTM
+
+	var st LODStats
+	var splits, merges []lodNode
+	var walk func(idx int)
+	walk = func(idx int) {
+		t := h.Trees[idx]
+		if t.Leaf() {
+			st.Triangles++
+			if t.Level >= l.MaxLevel {
+				return
+			}
+			if t.Level < l.MinLevel {
+				splits = append(splits, lodNode{idx, math.Inf(1)})
+			} else if err := l.error(idx, cam); err > l.Threshold {
+				splits = append(splits, lodNode{idx, err})
+			}
+			return
+		}
+		if t.Level >= l.MinLevel && childrenAreLeaves(h, idx) {
+			if err := l.error(idx, cam); err <= l.Threshold {
+				merges = append(merges, lodNode{idx, err})
+			}
+		}
+		for _, c := range t.Children {
+			walk(c)
+		}
+	}
+	for idx := 0; idx < h.Roots(); idx++ {
+		walk(idx)
+	}
+
+	over := func() bool { return l.Budget != 0 && time.Since(start) >= l.Budget }
+
+	// slots freed by merges may be reused by splits, so split candidates among them are dropped
+	freed := make(map[int]bool)
+	sort.Slice(merges, func(i, j int) bool { return merges[i].err < merges[j].err })
+	for _, n := range merges {
+		if over() {
+			st.Pending = true
+			return st
+		}
+		for _, c := range h.Trees[n.idx].Children {
+			freed[c] = true
+		}
+		mergeLeaves(h, n.idx)
+		st.Merges++
+		st.Triangles -= 3
+	}
+
+	sort.Slice(splits, func(i, j int) bool { return splits[i].err > splits[j].err })
+	for _, n := range splits {
+		if over() || (l.MaxTriangles != 0 && st.Triangles+3 > l.MaxTriangles) {
+			st.Pending = true
+			return st
+		}
+		if freed[n.idx] {
+			continue // culled along with a merged parent
+		}
+		SubDivide(h, n.idx, h.Trees[n.idx].Level+1)
+		st.Splits++
+		st.Triangles += 3
+	}
+	return st
+}
+
+// error returns the screen-space error of the node at idx as seen from cam, measured between the
+// output positions of its vertices.
+func (l *LOD) error(idx int, cam Vec3) float64 {
+	v0, v1, v2 := l.HTM.VerticesAt(idx)
+	p0, p1, p2 := l.HTM.PositionsAt(idx)
+	c := p0.Add(p1).Add(p2).DivScalar(3)
+	if !facing(cam, v0, p0) && !facing(cam, v1, p1) && !facing(cam, v2, p2) && !facing(cam, v0.Add(v1).Add(v2), c) {
+		return 0
+	}
+	e := math.Max(p0.Sub(p1).Length(), math.Max(p1.Sub(p2).Length(), p2.Sub(p0).Length()))
+	d := cam.Sub(c).Length()
+	if d == 0 {
+		return math.Inf(1)
+	}
+	scale := l.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return e / d * scale
+}
+
+// facing reports if the surface at position p, whose normal is taken as the vertex direction v,
+// faces cam.
+func facing(cam, v, p Vec3) bool {
+	return v.Dot(cam.Sub(p)) > 0
+}
@@ -0,0 +1,137 @@
+package htm
+
+import (
+	"fmt"
+	"math"
+)
+
+// MidpointFunc returns the vertex placed between v0 and v1 when an edge is subdivided.
+type MidpointFunc func(v0, v1 Vec3) Vec3
+
+// SphereMidpoint adds two vertices together and normalizes the result, projecting the
+// midpoint onto the unit sphere. This is the default.
+func SphereMidpoint(v0, v1 Vec3) Vec3 {
+	w, _ := v0.Add(v1).Normalized()
+	return w
+}
+
+// FlatMidpoint returns the point halfway between two vertices without normalizing so that
+// subdivision refines a mesh without changing its shape.
+func FlatMidpoint(v0, v1 Vec3) Vec3 {
+	return v0.Add(v1).MulScalar(0.5)
+}
+
+// octahedron vertices and faces are the base of New.
+var (
+	octahedronVertices = []Vec3{
+		{0, 0, 1},
+		{1, 0, 0},
+		{0, 1, 0},
+		{-1, 0, 0},
+		{0, -1, 0},
+		{0, 0, -1},
+	}
+	octahedronFaces = [][3]int{
+		{1, 5, 2}, // S0
+		{2, 5, 3}, // S1
+		{3, 5, 4}, // S2
+		{4, 5, 1}, // S3
+		{1, 0, 4}, // N0
+		{4, 0, 3}, // N1
+		{3, 0, 2}, // N2
+		{2, 0, 1}, // N3
+	}
+)
+
+// icosahedron vertices and faces are the base of NewIcosahedron. Vertices are normalized on init.
+var (
+	icosahedronVertices = []Vec3{
+		{-1, math.Phi, 0}, {1, math.Phi, 0}, {-1, -math.Phi, 0}, {1, -math.Phi, 0},
+		{0, -1, math.Phi}, {0, 1, math.Phi}, {0, -1, -math.Phi}, {0, 1, -math.Phi},
+		{math.Phi, 0, -1}, {math.Phi, 0, 1}, {-math.Phi, 0, -1}, {-math.Phi, 0, 1},
+	}
+	icosahedronFaces = [][3]int{
+		{0, 11, 5}, {0, 5, 1}, {0, 1, 7}, {0, 7, 10}, {0, 10, 11},
+		{1, 5, 9}, {5, 11, 4}, {11, 10, 2}, {10, 7, 6}, {7, 1, 8},
+		{3, 9, 4}, {3, 4, 2}, {3, 2, 6}, {3, 6, 8}, {3, 8, 9},
+		{4, 9, 5}, {2, 4, 11}, {6, 2, 10}, {8, 6, 7}, {9, 8, 1},
+	}
+)
+
+func init() {
+	for i, v := range icosahedronVertices {
+		icosahedronVertices[i], _ = v.Normalized()
+	}
+}
+
+// NewIcosahedron returns an HTM with twenty root nodes that create an icosahedron. Its cells
+// are more uniform in size and shape than those of the octahedron.
+func NewIcosahedron() *HTM {
+	h, err := NewMesh(icosahedronVertices, icosahedronFaces)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// NewMesh returns an HTM with a root node for each face of the given closed triangle mesh, every
+// edge of which must be shared by exactly two faces. Faces wind counter-clockwise seen from outside.
+// Vertices must be non-zero as the zero vector marks an empty vertex.
+//
+// The default midpoint rule projects onto the unit sphere; set Midpoint to FlatMidpoint to refine
+// an arbitrary mesh instead. Queries such as LookupByCart test containment by direction from the
+// origin and so require a mesh that is star-shaped about the origin.
+func NewMesh(vertices []Vec3, faces [][3]int) (*HTM, error) {
+	if len(faces) == 0 {
+		return nil, fmt.Errorf("mesh has no faces")
+	}
+	for i, v := range vertices {
+		if v.X == 0 && v.Y == 0 && v.Z == 0 {
+			return nil, fmt.Errorf("vertex %v is zero", i)
+		}
+	}
+
+	type edge struct{ start, end int }
+	shared := make(map[edge]int)
+	for i, f := range faces {
+		for k, x := range f {
+			if x < 0 || x >= len(vertices) {
+				return nil, fmt.Errorf("face %v indice %v out of range", i, x)
+			}
+			if x == f[(k+1)%3] {
+				return nil, fmt.Errorf("face %v is degenerate", i)
+			}
+		}
+		for k := range f {
+			e := edge{f[k], f[(k+1)%3]}
+			if e.start < e.end {
+				e.start, e.end = e.end, e.start
+			}
+			shared[e]++
+		}
+	}
+
+	for e, n := range shared {
+		if n != 2 {
+			return nil, fmt.Errorf("mesh is not closed, edge (%v, %v) is shared by %v faces", e.start, e.end, n)
+		}
+	}
+	h := &HTM{
+		Edges:    &Edges{},
+		Vertices: append([]Vec3(nil), vertices...),
+		Trees:    make([]Tree, len(faces)),
+		roots:    len(faces),
+	}
+	for i, f := range faces {
+		h.Trees[i] = Tree{Index: i, Level: 1, Indices: f, Parent: -1, Flags: TreeLive}
+	}
+	// initialize edges for root nodes
+	for _, tr := range h.Trees {
+		i0, i1, i2 := tr.Indices[0], tr.Indices[1], tr.Indices[2]
+		h.Edges.edge(i1, i2)
+		h.Edges.edge(i0, i2)
+		h.Edges.edge(i0, i1)
+	}
+	h.countRefs()
+	return h, nil
+}
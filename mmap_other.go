@@ -0,0 +1,15 @@
+//go:build !unix
+
+package htm
+
+import "os"
+
+// OpenView reads the file written by WriteTo at name as a View. Memory mapping is only supported
+// on unix, so the file is read into memory.
+func OpenView(name string) (*View, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewView(data)
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package htm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// OpenView maps the file written by WriteTo at name into memory as a View, shared with any other
+// process mapping it. Close unmaps it.
+func OpenView(name string) (*View, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("invalid file size %v", size)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	v, err := NewView(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	v.close = func() error { return syscall.Munmap(data) }
+	return v, nil
+}
@@ -0,0 +1,284 @@
+package htm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MOCMaxOrder is the deepest HEALPix order of a MOC.
+const MOCMaxOrder = 29
+
+// MOC is an IVOA Multi-Order Coverage map, the nested HEALPix pixels of each order in a region.
+// Normalize keeps each region as the fewest sorted and disjoint pixels.
+type MOC map[int][]uint64
+
+// check returns an error if m has an order or pixel out of range.
+func (m MOC) check() error {
+	for o, cells := range m {
+		if o < 0 || o > MOCMaxOrder {
+			return fmt.Errorf("order %v out of range", o)
+		}
+		for _, p := range cells {
+			if p >= 12<<(2*o) {
+				return fmt.Errorf("pixel %v out of range at order %v", p, o)
+			}
+		}
+	}
+	return nil
+}
+
+// ranges returns the pixels of m as sorted and disjoint ranges of pixels at MOCMaxOrder.
+func (m MOC) ranges() [][2]uint64 {
+	var rs [][2]uint64
+	for o, cells := range m {
+		s := 2 * (MOCMaxOrder - o)
+		for _, p := range cells {
+			rs = append(rs, [2]uint64{p << s, (p + 1) << s})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i][0] < rs[j][0] })
+	var merged [][2]uint64
+	for _, r := range rs {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Normalize merges pixels whose siblings are all present into their parent and removes pixels
+// within others, sorting the pixels of each order.
+func (m MOC) Normalize() {
+	rs := m.ranges()
+	clear(m)
+	for _, r := range rs {
+		m.addRange(r[0], r[1])
+	}
+}
+
+// addRange adds the pixels from lo up to hi at MOCMaxOrder to m as the fewest pixels.
+func (m MOC) addRange(lo, hi uint64) {
+	for lo < hi {
+		// the largest pixel starting at lo within the range
+		o := MOCMaxOrder
+		for o > 0 {
+			s := 2 * (MOCMaxOrder - o + 1)
+			if lo&(1<<s-1) != 0 || lo+1<<s > hi {
+				break
+			}
+			o--
+		}
+		s := 2 * (MOCMaxOrder - o)
+		m[o] = append(m[o], lo>>s)
+		lo += 1 << s
+	}
+}
+
+// Contains reports whether the direction v is in m. The pixels of each order must be sorted, as
+// Normalize leaves them.
+func (m MOC) Contains(v Vec3) bool {
+	p := HEALPixNest(MOCMaxOrder, v)
+	for o, cells := range m {
+		if _, ok := slices.BinarySearch(cells, p>>(2*(MOCMaxOrder-o))); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns whether ranges cover all, some or none of the pixel p of order.
+func status(rs [][2]uint64, order int, p uint64) Coverage {
+	s := 2 * (MOCMaxOrder - order)
+	lo, hi := p<<s, (p+1)<<s
+	i := sort.Search(len(rs), func(i int) bool { return rs[i][1] > lo })
+	switch {
+	case i == len(rs) || rs[i][0] >= hi:
+		return Outside
+	case rs[i][0] <= lo && rs[i][1] >= hi:
+		return Inside
+	}
+	return Partial
+}
+
+// pixel is a nested HEALPix pixel with its bounding cap.
+type pixel struct {
+	order int
+	p     uint64
+	bc    boundingCap
+}
+
+// healpix returns the hierarchy of nested pixels down to order.
+func healpix(order int) Hierarchy[pixel] {
+	at := func(o int, p uint64) pixel { return pixel{o, p, pixelCap(o, p)} }
+	x := Hierarchy[pixel]{
+		Children: func(px pixel) []pixel {
+			if px.order >= order {
+				return nil
+			}
+			cs := make([]pixel, 4)
+			for k := range cs {
+				cs[k] = at(px.order+1, px.p<<2|uint64(k))
+			}
+			return cs
+		},
+		Intersects: func(px pixel, v0, v1, v2 Vec3) bool { return px.bc.intersects(v0, v1, v2) },
+	}
+	for f := uint64(0); f < 12; f++ {
+		x.Roots = append(x.Roots, at(0, f))
+	}
+	return x
+}
+
+// MOC returns the region of the nodes at the given indices, such as the result of Intersections,
+// as a normalized MOC of pixels up to order, as with RegionCells.
+func (h *HTM) MOC(cover []int, order int, mode CoverMode) MOC {
+	if order > MOCMaxOrder {
+		order = MOCMaxOrder
+	}
+	m := MOC{}
+	for _, px := range RegionCells(h, cover, healpix(order), mode) {
+		m[px.order] = append(m[px.order], px.p)
+	}
+	m.Normalize()
+	return m
+}
+
+// CoverMOC returns the nodes of h in the region of m as with RegionCover.
+func (h *HTM) CoverMOC(m MOC, mode CoverMode) []int {
+	rs := m.ranges()
+	return RegionCover(h, healpix(MOCMaxOrder), func(px pixel) Coverage { return status(rs, px.order, px.p) }, mode)
+}
+
+// orders returns the orders of m with pixels, in increasing order.
+func (m MOC) orders() []int {
+	var orders []int
+	for o, cells := range m {
+		if len(cells) > 0 {
+			orders = append(orders, o)
+		}
+	}
+	sort.Ints(orders)
+	return orders
+}
+
+// MarshalText encodes m in the MOC ASCII serialization, such as "1/0-2 8 2/40", with consecutive
+// pixels of each order as ranges.
+func (m MOC) MarshalText() ([]byte, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, o := range m.orders() {
+		cells := append([]uint64(nil), m[o]...)
+		sort.Slice(cells, func(i, j int) bool { return cells[i] < cells[j] })
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%d/", o)
+		for i := 0; i < len(cells); {
+			j := i
+			for j+1 < len(cells) && cells[j+1] <= cells[j]+1 {
+				j++
+			}
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(strconv.FormatUint(cells[i], 10))
+			if cells[j] != cells[i] {
+				fmt.Fprintf(&buf, "-%d", cells[j])
+			}
+			i = j + 1
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText decodes the MOC ASCII serialization into m as a normalized MOC, replacing its
+// contents. Values may be separated by spaces or commas, and orders without pixels are allowed.
+func (m *MOC) UnmarshalText(text []byte) error {
+	x := MOC{}
+	order := -1
+	fields := strings.FieldsFunc(string(text), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	for _, f := range fields {
+		if o, rest, ok := strings.Cut(f, "/"); ok {
+			n, err := strconv.Atoi(o)
+			if err != nil || n < 0 || n > MOCMaxOrder {
+				return fmt.Errorf("invalid order %q", o)
+			}
+			order, f = n, rest
+			if f == "" {
+				continue
+			}
+		}
+		if order == -1 {
+			return fmt.Errorf("pixel %q before order", f)
+		}
+		lo, hi, isRange := strings.Cut(f, "-")
+		a, err := strconv.ParseUint(lo, 10, 64)
+		if err != nil {
+			return err
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.ParseUint(hi, 10, 64); err != nil {
+				return err
+			}
+		}
+		if b < a || b >= 12<<(2*order) {
+			return fmt.Errorf("invalid pixels %q at order %v", f, order)
+		}
+		s := 2 * (MOCMaxOrder - order)
+		x.addRange(a<<s, (b+1)<<s)
+	}
+	x.Normalize()
+	*m = x
+	return nil
+}
+
+// MarshalJSON encodes m in the MOC JSON serialization, such as {"1":[0,1,2,8],"2":[40]}.
+func (m MOC) MarshalJSON() ([]byte, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	js := make(map[string][]uint64)
+	for _, o := range m.orders() {
+		js[strconv.Itoa(o)] = m[o]
+	}
+	return json.Marshal(js)
+}
+
+// UnmarshalJSON decodes the MOC JSON serialization into m as a normalized MOC, replacing its
+// contents.
+func (m *MOC) UnmarshalJSON(data []byte) error {
+	var js map[string][]uint64
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	x := MOC{}
+	for k, cells := range js {
+		o, err := strconv.Atoi(k)
+		if err != nil {
+			return fmt.Errorf("invalid order %q", k)
+		}
+		if len(cells) > 0 {
+			x[o] = append(x[o], cells...)
+		}
+	}
+	if err := x.check(); err != nil {
+		return err
+	}
+	x.Normalize()
+	*m = x
+	return nil
+}
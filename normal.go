@@ -0,0 +1,127 @@
+package htm
+
+import "math"
+
+// faceNormal returns the unnormalized normal of the node at idx from its output positions. Its
+// length is twice the area of the triangle.
+func faceNormal(h *HTM, idx int) Vec3 {
+	p0, p1, p2 := h.PositionsAt(idx)
+	return p1.Sub(p0).Cross(p2.Sub(p0))
+}
+
+// Normals returns smooth vertex normals in the same order as Vertices, averaged from the leaves
+// sharing each vertex and weighted by their area. Output positions are used, so normals follow
+// a displaced surface rather than the sphere. Empty vertices have a zero normal.
+func (h *HTM) Normals() []Vec3 {
+	n := make([]Vec3, len(h.Vertices))
+	for idx, t := range h.Trees {
+		if t.Empty() || !t.Leaf() {
+			continue
+		}
+		fn := faceNormal(h, idx)
+		for _, i := range t.Indices {
+			n[i] = n[i].Add(fn)
+		}
+	}
+	for i, v := range n {
+		n[i], _ = v.Normalized()
+	}
+	return n
+}
+
+// FaceNormals returns the flat normal of each leaf, in the same order as the triangles of Indices.
+func (h *HTM) FaceNormals() []Vec3 {
+	var n []Vec3
+	for idx, t := range h.Trees {
+		if !t.Empty() && t.Leaf() {
+			fn, _ := faceNormal(h, idx).Normalized()
+			n = append(n, fn)
+		}
+	}
+	return n
+}
+
+// Flat returns unindexed triangles of the leaves for flat shading, with three positions, face
+// normals and UV coordinates per triangle.
+func (h *HTM) Flat() (positions, normals []Vec3, texcoords []float32) {
+	var dirs []Vec3
+	for idx, t := range h.Trees {
+		if t.Empty() || !t.Leaf() {
+			continue
+		}
+		p0, p1, p2 := h.PositionsAt(idx)
+		v0, v1, v2 := h.VerticesAt(idx)
+		fn, _ := faceNormal(h, idx).Normalized()
+		positions = append(positions, p0, p1, p2)
+		normals = append(normals, fn, fn, fn)
+		dirs = append(dirs, v0, v1, v2)
+	}
+	return positions, normals, TexCoords(dirs)
+}
+
+// Tangents returns a tangent frame for each vertex, in the same order as Vertices, consistent
+// with the UV coordinates of TexCoords. Tangents point along increasing U and bitangents along
+// increasing V, both orthogonal to the smooth normal of Normals.
+func (h *HTM) Tangents() (tangents, bitangents []Vec3) {
+	normals := h.Normals()
+	uv := TexCoords(h.Vertices)
+	tan := make([]Vec3, len(h.Vertices))
+	bit := make([]Vec3, len(h.Vertices))
+
+	for idx, t := range h.Trees {
+		if t.Empty() || !t.Leaf() {
+			continue
+		}
+		i0, i1, i2 := t.Indices[0], t.Indices[1], t.Indices[2]
+		p0, p1, p2 := h.PositionsAt(idx)
+		u0, v0 := float64(uv[i0*2]), float64(uv[i0*2+1])
+		u1, v1 := float64(uv[i1*2]), float64(uv[i1*2+1])
+		u2, v2 := float64(uv[i2*2]), float64(uv[i2*2+1])
+
+		// unwrap triangles crossing the texture seam
+		u1, u2 = unwrap(u0, u1), unwrap(u0, u2)
+
+		e1, e2 := p1.Sub(p0), p2.Sub(p0)
+		du1, dv1 := u1-u0, v1-v0
+		du2, dv2 := u2-u0, v2-v0
+		r := du1*dv2 - du2*dv1
+		if r == 0 {
+			continue
+		}
+		sdir := e1.MulScalar(dv2).Sub(e2.MulScalar(dv1)).DivScalar(r)
+		tdir := e2.MulScalar(du1).Sub(e1.MulScalar(du2)).DivScalar(r)
+		for _, i := range t.Indices {
+			tan[i] = tan[i].Add(sdir)
+			bit[i] = bit[i].Add(tdir)
+		}
+	}
+
+	for i, n := range normals {
+		if n.X == 0 && n.Y == 0 && n.Z == 0 {
+			tan[i], bit[i] = Vec3{}, Vec3{}
+			continue
+		}
+		// Gram-Schmidt orthogonalize, falling back to east where UVs are degenerate at the poles
+		t, ok := tan[i].Sub(n.MulScalar(n.Dot(tan[i]))).Normalized()
+		if !ok {
+			t, ok = Vec3{X: -n.Y, Y: n.X}.Normalized()
+			if !ok {
+				t = Vec3{X: 1}
+			}
+		}
+		b := n.Cross(t)
+		if b.Dot(bit[i]) < 0 {
+			b = b.MulScalar(-1)
+		}
+		tan[i], bit[i] = t, b
+	}
+	return tan, bit
+}
+
+// unwrap returns u shifted by one if it lies across the texture seam from u0.
+func unwrap(u0, u float64) float64 {
+	if d := u - u0; math.Abs(d) > 0.5 {
+		return u - math.Copysign(1, d)
+	}
+	return u
+}
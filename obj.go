@@ -0,0 +1,129 @@
+package htm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OBJOptions select the optional attributes written by WriteOBJ.
+type OBJOptions struct {
+	TexCoords bool // UV coordinates of TexCoords
+	Normals   bool // smooth normals of Normals
+}
+
+// WriteOBJ writes the leaves of h as a Wavefront OBJ mesh of output positions. Only vertices used
+// by a leaf are written, numbered in the order of Vertices. Leaves of unequal levels meet at
+// T-junctions, so only a uniformly subdivided HTM writes a closed mesh that ReadOBJ accepts.
+func (h *HTM) WriteOBJ(w io.Writer, opts OBJOptions) error {
+	indices := h.Indices()
+	used := make([]int, len(h.Vertices))
+	for _, i := range indices {
+		used[i] = 1
+	}
+	n := 0
+	for i, u := range used {
+		if u != 0 {
+			n++
+			used[i] = n
+		}
+	}
+
+	var uv []float32
+	if opts.TexCoords {
+		uv = TexCoords(h.Vertices)
+	}
+	var normals []Vec3
+	if opts.Normals {
+		normals = h.Normals()
+	}
+
+	bw := bufio.NewWriter(w)
+	f := func(x float64) string { return strconv.FormatFloat(x, 'g', -1, 64) }
+	for i := range h.Vertices {
+		if used[i] == 0 {
+			continue
+		}
+		p := h.PositionAt(i)
+		fmt.Fprintf(bw, "v %s %s %s\n", f(p.X), f(p.Y), f(p.Z))
+		if uv != nil {
+			fmt.Fprintf(bw, "vt %s %s\n", f(float64(uv[i*2])), f(float64(1-uv[i*2+1])))
+		}
+		if normals != nil {
+			fmt.Fprintf(bw, "vn %s %s %s\n", f(normals[i].X), f(normals[i].Y), f(normals[i].Z))
+		}
+	}
+	for k := 0; k < len(indices); k += 3 {
+		bw.WriteString("f")
+		for _, i := range indices[k : k+3] {
+			x := used[i]
+			switch {
+			case uv != nil && normals != nil:
+				fmt.Fprintf(bw, " %d/%d/%d", x, x, x)
+			case uv != nil:
+				fmt.Fprintf(bw, " %d/%d", x, x)
+			case normals != nil:
+				fmt.Fprintf(bw, " %d//%d", x, x)
+			default:
+				fmt.Fprintf(bw, " %d", x)
+			}
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// ReadOBJ reads the vertices and triangles of a Wavefront OBJ mesh as the root nodes of an HTM, as
+// NewMesh. Texture coordinates, normals, groups and materials are ignored.
+func ReadOBJ(r io.Reader) (*HTM, error) {
+	var vertices []Vec3
+	var faces [][3]int
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %v: vertex has %v coordinates", line, len(fields)-1)
+			}
+			var c [3]float64
+			for k := range c {
+				x, err := strconv.ParseFloat(fields[k+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %v", line, err)
+				}
+				c[k] = x
+			}
+			vertices = append(vertices, Vec3{c[0], c[1], c[2]})
+		case "f":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("line %v: face has %v vertices, only triangles are supported", line, len(fields)-1)
+			}
+			var face [3]int
+			for k := range face {
+				ref, _, _ := strings.Cut(fields[k+1], "/")
+				x, err := strconv.Atoi(ref)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %v", line, err)
+				}
+				// one based, or relative to the last vertex if negative
+				if x < 0 {
+					x += len(vertices)
+				} else {
+					x--
+				}
+				face[k] = x
+			}
+			faces = append(faces, face)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return NewMesh(vertices, faces)
+}
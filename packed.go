@@ -0,0 +1,280 @@
+package htm
+
+import (
+	"fmt"
+	"iter"
+)
+
+// NoChild marks a node of Packed without children.
+const NoChild = ^uint32(0)
+
+// Packed is a compact, read-only layout of an HTM for storage and queries. Nodes are stored breadth
+// first with the children of a node next to each other, so that within the complete levels, where
+// every node has children, the children of node i are found at Roots+4i+k without being stored.
+// Only nodes from the last complete level on hold the position of their first child. Indices are
+// uint32 and positions may be float32. Edges are not stored; Unpack rebuilds them for subdivision.
+//
+// For a uniformly subdivided HTM, a node takes 12 bytes and a vertex 24, or 12 with float32
+// positions, against 88 and 24 plus six edges of 24 for an HTM.
+type Packed struct {
+	Roots    int
+	Complete int // number of complete levels, where nodes of each but the last have children
+
+	Starts     []uint32 // position of the first node of each level from level one, then the number of nodes
+	Indices    []uint32 // three vertex indices per node
+	Children   []uint32 // first child of each node from level Complete on, or NoChild
+	Vertices   []Vec3   // nil if positions are float32
+	Vertices32 []Vec3f  // nil if positions are float64
+}
+
+// Pack returns the nodes and live vertices of h in a Packed layout with float64 positions.
+func Pack(h *HTM) *Packed {
+	return pack(h, false)
+}
+
+// PackFloat32 returns the nodes and live vertices of h in a Packed layout with float32 positions.
+func PackFloat32(h *HTM) *Packed {
+	return pack(h, true)
+}
+
+func pack(h *HTM, f32 bool) *Packed {
+	p := &Packed{Roots: h.roots}
+
+	vmap := make([]uint32, len(h.Vertices))
+	for i, v := range h.Vertices {
+		if v == Vec3Zero {
+			continue
+		}
+		vmap[i] = uint32(p.vertexCount())
+		if f32 {
+			p.Vertices32 = append(p.Vertices32, v.Vec3f())
+		} else {
+			p.Vertices = append(p.Vertices, v)
+		}
+	}
+
+	// breadth first order, one level at a time
+	var nodes []int
+	level := make([]int, h.roots)
+	for idx := range level {
+		level[idx] = idx
+	}
+	complete := true
+	for len(level) > 0 {
+		p.Starts = append(p.Starts, uint32(len(nodes)))
+		nodes = append(nodes, level...)
+		if complete {
+			p.Complete = len(p.Starts)
+		}
+		var next []int
+		for _, idx := range level {
+			if h.Trees[idx].Leaf() {
+				complete = false
+				continue
+			}
+			next = append(next, h.Trees[idx].Children[:]...)
+		}
+		level = next
+	}
+	p.Starts = append(p.Starts, uint32(len(nodes)))
+
+	p.Indices = make([]uint32, 0, len(nodes)*3)
+	for _, idx := range nodes {
+		for _, i := range h.Trees[idx].Indices {
+			p.Indices = append(p.Indices, vmap[i])
+		}
+	}
+
+	first := int(p.Starts[p.Complete-1])
+	pos := make([]uint32, len(h.Trees))
+	for i, idx := range nodes[first:] {
+		pos[idx] = uint32(first + i)
+	}
+	p.Children = make([]uint32, len(nodes)-first)
+	for i, idx := range nodes[first:] {
+		p.Children[i] = NoChild
+		if t := h.Trees[idx]; !t.Leaf() {
+			p.Children[i] = pos[t.Children[0]]
+		}
+	}
+	return p
+}
+
+func (p *Packed) vertexCount() int {
+	if p.Vertices32 != nil {
+		return len(p.Vertices32)
+	}
+	return len(p.Vertices)
+}
+
+// Len returns the number of nodes.
+func (p *Packed) Len() int {
+	return len(p.Indices) / 3
+}
+
+// Level returns the subdivision level of node i.
+func (p *Packed) Level(i int) int {
+	l := 1
+	for l+1 < len(p.Starts) && uint32(i) >= p.Starts[l] {
+		l++
+	}
+	return l
+}
+
+// Child returns the first of the four children of node i, or false if it has none.
+func (p *Packed) Child(i int) (int, bool) {
+	if first := int(p.Starts[p.Complete-1]); i >= first {
+		c := p.Children[i-first]
+		return int(c), c != NoChild
+	}
+	return p.Roots + 4*i, true
+}
+
+// Leaf reports if node i has no children.
+func (p *Packed) Leaf(i int) bool {
+	_, ok := p.Child(i)
+	return !ok
+}
+
+// Vertex returns the vertex at index i.
+func (p *Packed) Vertex(i uint32) Vec3 {
+	if p.Vertices32 != nil {
+		return p.Vertices32[i].Vec3()
+	}
+	return p.Vertices[i]
+}
+
+// VerticesAt returns the vertices of node i.
+func (p *Packed) VerticesAt(i int) (v0, v1, v2 Vec3) {
+	return p.Vertex(p.Indices[i*3]), p.Vertex(p.Indices[i*3+1]), p.Vertex(p.Indices[i*3+2])
+}
+
+// LookupByCart returns the leaf containing v.
+func (p *Packed) LookupByCart(v Vec3) (int, error) {
+	for idx := 0; idx < p.Roots; idx++ {
+		if v0, v1, v2 := p.VerticesAt(idx); !inside(v0, v1, v2, v) {
+			continue
+		}
+		for i := idx; ; {
+			c, ok := p.Child(i)
+			if !ok {
+				return i, nil
+			}
+			next := -1
+			for k := 0; k < 4 && next == -1; k++ {
+				if v0, v1, v2 := p.VerticesAt(c + k); inside(v0, v1, v2, v) {
+					next = c + k
+				}
+			}
+			if next == -1 {
+				break
+			}
+			i = next
+		}
+	}
+	return -1, fmt.Errorf("Failed to lookup triangle by given cartesian coordinates: %v", v)
+}
+
+// Intersections returns the nodes that fully or partially match t as HTM.Intersections does.
+func (p *Packed) Intersections(t Tester) []int {
+	var mt []int
+	for idx := 0; idx < p.Roots; idx++ {
+		p.intersections(idx, t, &mt)
+	}
+	return mt
+}
+
+func (p *Packed) intersections(i int, t Tester, mt *[]int) {
+	cv := t.Test(p.VerticesAt(i))
+	c, ok := p.Child(i)
+	if cv == Inside || (cv == Partial && !ok) {
+		*mt = append(*mt, i)
+		return
+	}
+	if !ok {
+		return
+	}
+	for k := 0; k < 4; k++ {
+		p.intersections(c+k, t, mt)
+	}
+}
+
+// Leaves returns an iterator over the leaves at or below each of the given nodes, depth first.
+func (p *Packed) Leaves(positions ...int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for _, i := range positions {
+			if !p.leaves(i, yield) {
+				return
+			}
+		}
+	}
+}
+
+func (p *Packed) leaves(i int, yield func(int) bool) bool {
+	c, ok := p.Child(i)
+	if !ok {
+		return yield(i)
+	}
+	for k := 0; k < 4; k++ {
+		if !p.leaves(c+k, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// LeafIndices returns the vertex indices of all leaves, as HTM.Indices.
+func (p *Packed) LeafIndices() []uint32 {
+	var indices []uint32
+	for i := 0; i < p.Len(); i++ {
+		if p.Leaf(i) {
+			indices = append(indices, p.Indices[i*3:i*3+3]...)
+		}
+	}
+	return indices
+}
+
+// Unpack returns an HTM with the nodes and vertices of p, numbered as in p, with edges rebuilt so
+// that it may be subdivided further.
+func (p *Packed) Unpack() *HTM {
+	n := p.vertexCount()
+	h := &HTM{
+		Edges:    &Edges{},
+		Vertices: make([]Vec3, n),
+		Trees:    make([]Tree, p.Len()),
+		roots:    p.Roots,
+	}
+	for i := range h.Vertices {
+		h.Vertices[i] = p.Vertex(uint32(i))
+	}
+	for i := range h.Trees {
+		t := &h.Trees[i]
+		if i < p.Roots {
+			t.Parent = -1
+		}
+		t.Index, t.Level, t.Flags = i, p.Level(i), TreeLive
+		t.Indices = [3]int{int(p.Indices[i*3]), int(p.Indices[i*3+1]), int(p.Indices[i*3+2])}
+		if c, ok := p.Child(i); ok {
+			t.Children = [4]int{c, c + 1, c + 2, c + 3}
+			t.Flags |= TreeSplit
+			for k := 0; k < 4; k++ {
+				h.Trees[c+k].Parent = i
+			}
+		}
+	}
+
+	for _, t := range h.Trees {
+		i0, i1, i2 := t.Indices[0], t.Indices[1], t.Indices[2]
+		h.Edges.edge(i1, i2)
+		h.Edges.edge(i0, i2)
+		h.Edges.edge(i0, i1)
+		if !t.Leaf() {
+			e0, e1, e2 := h.IndicesAt(t.Children[3])
+			h.Edges.SetMid(i1, i2, e0)
+			h.Edges.SetMid(i0, i2, e1)
+			h.Edges.SetMid(i0, i1, e2)
+		}
+	}
+	h.countRefs()
+	return h
+}
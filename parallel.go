@@ -0,0 +1,357 @@
+package htm
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// SubDivideParallel subdivides the node at idx and its descendants until they reach the given level,
+// as SubDivide, spreading the work over GOMAXPROCS goroutines. The result is identical to that of
+// SubDivide, including the indices of new trees and vertices, the order of Edges and the order of
+// changes reported to OnChange. The HTM's Midpoint must be safe for concurrent use.
+//
+// Each leaf to be subdivided is first split on its own with locally numbered vertices and trees.
+// The leaves are then visited in the order SubDivide would have taken to assign indices, resolving
+// midpoints shared along their sides so each is computed once, before the local results are
+// written out concurrently. Edges whose group may also be written by another leaf are written last,
+// in order.
+func SubDivideParallel(h *HTM, idx int, level int) {
+	subDivideUnits(h, collectUnits(h, idx, level, nil), level)
+}
+
+// SubDivideParallel subdivides all root nodes until they reach the given level. See the function
+// of the same name.
+func (h *HTM) SubDivideParallel(level int) {
+	var units []*unit
+	for idx := 0; idx < h.roots; idx++ {
+		units = collectUnits(h, idx, level, units)
+	}
+	subDivideUnits(h, units, level)
+}
+
+// unit is a leaf subdivided apart from others by SubDivideParallel. Its corners are local vertices
+// 0, 1 and 2 followed by those it creates, and the leaf itself is local tree 0 followed by its
+// descendants.
+type unit struct {
+	idx    int
+	verts  []unitVertex
+	trees  []Tree
+	ops    []edgeOp
+	events []Change
+
+	vmap     []int        // global indices of local vertices
+	tmap     []int        // global indices of local trees
+	vlo, vhi int          // range of appended vertices owned by unit
+	popped   map[int]bool // reused vertices owned by unit
+
+	overflow map[int][]Edge // overflow of owned groups
+	deferred []edgeOp       // global edges of groups not owned by unit
+	refs     map[int]int    // reference changes of vertices not owned by unit
+}
+
+// unitVertex is a vertex created by a unit as the midpoint of local vertices v0 and v1.
+type unitVertex struct {
+	v0, v1 int
+	sides  uint8 // sides of the leaf the vertex lies on, each bit for the side opposite a corner
+	v      Vec3
+}
+
+// edgeOp is an edge initialized during subdivision, setting its midpoint if mid is not -1.
+type edgeOp struct {
+	start, end, mid int
+}
+
+// owns reports if the global vertex i was created by the unit.
+func (u *unit) owns(i int) bool {
+	return (i >= u.vlo && i < u.vhi) || u.popped[i]
+}
+
+// collectUnits appends the leaves at or below idx that SubDivide would split, in the order it visits them.
+func collectUnits(h *HTM, idx int, level int, units []*unit) []*unit {
+	t := h.Trees[idx]
+	if t.Level >= level {
+		return units
+	}
+	if !t.Leaf() {
+		for _, c := range t.Children {
+			units = collectUnits(h, c, level, units)
+		}
+		return units
+	}
+	return append(units, &unit{idx: idx})
+}
+
+// subDivideUnits subdivides each unit to the given level and merges the results into h.
+func subDivideUnits(h *HTM, units []*unit, level int) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers == 1 || len(units) < 2 {
+		for _, u := range units {
+			SubDivide(h, u.idx, level)
+		}
+		return
+	}
+	events := h.OnChange != nil
+	parallel(workers, len(units), func(i int) { units[i].subdivide(h, level, events) })
+	assignUnits(h, units)
+	parallel(workers, len(units), func(i int) { units[i].apply(h) })
+	finishUnits(h, units)
+}
+
+// parallel calls f for each of 0 through n-1 on the given number of goroutines.
+func parallel(workers, n int, f func(i int)) {
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// subdivide splits the leaf of u with local indices, recording what SubDivide would do in order.
+func (u *unit) subdivide(h *HTM, level int, events bool) {
+	t := h.Trees[u.idx]
+	v0, v1, v2 := h.VerticesAt(u.idx)
+	u.verts = []unitVertex{{sides: 6, v: v0}, {sides: 5, v: v1}, {sides: 3, v: v2}}
+	u.trees = []Tree{{Level: t.Level, Indices: [3]int{0, 1, 2}, Parent: -1}}
+	mids := make(map[[2]int]int)
+
+	getMid := func(i0, i1 int) int {
+		key := [2]int{i0, i1}
+		if i0 < i1 {
+			key = [2]int{i1, i0}
+		}
+		mid, ok := mids[key]
+		if !ok {
+			mid = len(u.verts)
+			mids[key] = mid
+			x0, x1 := u.verts[i0], u.verts[i1]
+			u.verts = append(u.verts, unitVertex{v0: i0, v1: i1, sides: x0.sides & x1.sides, v: h.midpoint(x0.v, x1.v)})
+			if events {
+				u.events = append(u.events, Change{Kind: VertexAdded, Index: mid})
+			}
+		}
+		u.ops = append(u.ops, edgeOp{i0, i1, mid})
+		return mid
+	}
+	newTree := func(t Tree) int {
+		u.trees = append(u.trees, t)
+		return len(u.trees) - 1
+	}
+	initEdges := func(pairs ...int) {
+		for i := 0; i < len(pairs); i += 2 {
+			u.ops = append(u.ops, edgeOp{pairs[i], pairs[i+1], -1})
+		}
+	}
+
+	// split follows SubDivide step for step.
+	var split func(idx int)
+	split = func(idx int) {
+		if u.trees[idx].Level >= level {
+			return
+		}
+		i0, i1, i2 := u.trees[idx].Indices[0], u.trees[idx].Indices[1], u.trees[idx].Indices[2]
+		e0 := getMid(i1, i2)
+		e1 := getMid(i0, i2)
+		e2 := getMid(i0, i1)
+
+		l := u.trees[idx].Level + 1
+		a := newTree(Tree{Level: l, Indices: [3]int{i0, e2, e1}, Parent: idx})
+		b := newTree(Tree{Level: l, Indices: [3]int{i1, e0, e2}, Parent: idx})
+		c := newTree(Tree{Level: l, Indices: [3]int{i2, e1, e0}, Parent: idx})
+		d := newTree(Tree{Level: l, Indices: [3]int{e0, e1, e2}, Parent: idx})
+		u.trees[idx].Children = [4]int{a, b, c, d}
+		u.trees[idx].Flags |= TreeSplit
+		if events {
+			u.events = append(u.events, Change{Kind: TriangleRemoved, Index: idx},
+				Change{Kind: TriangleAdded, Index: a}, Change{Kind: TriangleAdded, Index: b},
+				Change{Kind: TriangleAdded, Index: c}, Change{Kind: TriangleAdded, Index: d})
+		}
+
+		initEdges(e2, e1, i0, e1, i0, e2, e0, e2, i1, e2, i1, e0, e1, e0, i2, e0, i2, e1, e1, e2, e0, e2, e0, e1)
+
+		split(a)
+		split(b)
+		split(c)
+		split(d)
+	}
+	split(0)
+}
+
+// assignUnits gives global indices to the local vertices and trees of units in order, taking
+// empty slots held for reuse first as newVertex and newTree would. A midpoint on the side of a
+// leaf is resolved to the vertex of a neighbor that created it before, if any.
+func assignUnits(h *HTM, units []*unit) {
+	nv, nt := len(h.Vertices), len(h.Trees)
+	created := make(map[[2]int]int)
+	for _, u := range units {
+		u.vmap = make([]int, len(u.verts))
+		u.vmap[0], u.vmap[1], u.vmap[2] = h.IndicesAt(u.idx)
+		u.vlo = nv
+		for i := 3; i < len(u.verts); i++ {
+			x := u.verts[i]
+			g0, g1 := u.vmap[x.v0], u.vmap[x.v1]
+			if g0 < g1 {
+				g0, g1 = g1, g0
+			}
+			key := [2]int{g0, g1}
+			if x.sides != 0 {
+				if m, ok := created[key]; ok {
+					u.vmap[i] = m
+					continue
+				}
+				if m := h.Edges.mid(g0, g1); m != -1 {
+					u.vmap[i] = m
+					continue
+				}
+			}
+			if n := len(h.freeVertices); n > 0 {
+				u.vmap[i] = h.freeVertices[n-1]
+				h.freeVertices = h.freeVertices[:n-1]
+				if u.popped == nil {
+					u.popped = make(map[int]bool)
+				}
+				u.popped[u.vmap[i]] = true
+			} else {
+				u.vmap[i] = nv
+				nv++
+			}
+			if x.sides != 0 {
+				created[key] = u.vmap[i]
+			}
+		}
+		u.vhi = nv
+
+		u.tmap = make([]int, len(u.trees))
+		u.tmap[0] = u.idx
+		for i := 1; i < len(u.trees); i++ {
+			if n := len(h.freeTrees); n > 0 {
+				u.tmap[i] = h.freeTrees[n-1]
+				h.freeTrees = h.freeTrees[:n-1]
+			} else {
+				u.tmap[i] = nt
+				nt++
+			}
+		}
+	}
+
+	if nv > len(h.Vertices) {
+		h.Vertices = append(h.Vertices, make([]Vec3, nv-len(h.Vertices))...)
+	}
+	for len(h.refs) < nv {
+		h.refs = append(h.refs, 0)
+	}
+	if nt > len(h.Trees) {
+		h.Trees = append(h.Trees, make([]Tree, nt-len(h.Trees))...)
+	}
+	h.Edges.grow(nv - 1)
+}
+
+// apply writes the vertices, trees and edges of u that no other unit writes.
+func (u *unit) apply(h *HTM) {
+	for i := 3; i < len(u.verts); i++ {
+		if g := u.vmap[i]; u.owns(g) {
+			h.Vertices[g] = u.verts[i].v
+		}
+	}
+
+	ref := func(t Tree, n int) {
+		for _, i := range t.Indices {
+			g := u.vmap[i]
+			if u.owns(g) {
+				h.refs[g] += n
+			} else {
+				if u.refs == nil {
+					u.refs = make(map[int]int)
+				}
+				u.refs[g] += n
+			}
+		}
+	}
+	for i, t := range u.trees {
+		if i == 0 {
+			ref(t, -1)
+		} else if t.Leaf() {
+			ref(t, 1)
+		}
+		x := h.Trees[u.tmap[i]]
+		if i != 0 {
+			x = Tree{Index: u.tmap[i], Level: t.Level, Parent: u.tmap[t.Parent], Flags: TreeLive}
+			for k, v := range t.Indices {
+				x.Indices[k] = u.vmap[v]
+			}
+		}
+		if !t.Leaf() {
+			for k, c := range t.Children {
+				x.Children[k] = u.tmap[c]
+			}
+			x.Flags |= TreeSplit
+		}
+		h.Trees[u.tmap[i]] = x
+	}
+
+	for _, op := range u.ops {
+		x := edgeOp{u.vmap[op.start], u.vmap[op.end], -1}
+		if op.mid != -1 {
+			x.mid = u.vmap[op.mid]
+		}
+		start := x.start
+		if x.end > start {
+			start = x.end
+		}
+		if !u.owns(start) {
+			u.deferred = append(u.deferred, x)
+			continue
+		}
+		e, _ := h.Edges.edgeIn(&u.overflow, x.start, x.end)
+		if e.Mid == -1 {
+			e.Mid = x.mid
+		}
+	}
+	u.verts, u.trees, u.ops = nil, nil, nil
+}
+
+// finishUnits writes what units share in order: overflow edges, edges of groups written by more
+// than one unit, reference counts and changes.
+func finishUnits(h *HTM, units []*unit) {
+	for _, u := range units {
+		for start, o := range u.overflow {
+			if h.Edges.overflow == nil {
+				h.Edges.overflow = make(map[int][]Edge)
+			}
+			h.Edges.overflow[start] = o
+		}
+	}
+	for _, u := range units {
+		for _, x := range u.deferred {
+			e, _ := h.Edges.edge(x.start, x.end)
+			if e.Mid == -1 {
+				e.Mid = x.mid
+			}
+		}
+		for i, n := range u.refs {
+			h.refs[i] += n
+		}
+	}
+	if h.OnChange == nil {
+		return
+	}
+	for _, u := range units {
+		for _, c := range u.events {
+			switch c.Kind {
+			case VertexAdded:
+				if g := u.vmap[c.Index]; u.owns(g) {
+					h.change(VertexAdded, g)
+				}
+			default:
+				h.change(c.Kind, u.tmap[c.Index])
+			}
+		}
+	}
+}
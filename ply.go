@@ -0,0 +1,208 @@
+package htm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// PLYProperty is a scalar property written for each vertex or face by WritePLY. Value is called
+// with the index of a vertex in Vertices or of a leaf in Trees.
+type PLYProperty struct {
+	Name  string
+	Type  string // char, uchar, short, ushort, int, uint, float or double
+	Value func(i int) float64
+}
+
+// PLYOptions select the encoding and the additional properties written by WritePLY.
+type PLYOptions struct {
+	Binary  bool // binary little endian rather than ASCII
+	Normals bool // smooth normals of Normals as nx, ny and nz
+	Vertex  []PLYProperty
+	Face    []PLYProperty
+}
+
+// LevelProperty returns a face property of each leaf's level.
+func (h *HTM) LevelProperty() PLYProperty {
+	return PLYProperty{Name: "level", Type: "uchar", Value: func(idx int) float64 {
+		return float64(h.Trees[idx].Level)
+	}}
+}
+
+// IDProperty returns a face property of each leaf's trixel ID. PLY has no 64 bit integers, so
+// the ID is written as a double which is exact for IDs up to 2^53.
+func (h *HTM) IDProperty() PLYProperty {
+	return PLYProperty{Name: "id", Type: "double", Value: func(idx int) float64 {
+		return float64(h.ID(idx))
+	}}
+}
+
+// CoverageProperty returns a face property of each leaf's coverage by t as in Coverage.
+func (h *HTM) CoverageProperty(t Tester) PLYProperty {
+	return PLYProperty{Name: "coverage", Type: "uchar", Value: func(idx int) float64 {
+		return float64(t.Test(h.VerticesAt(idx)))
+	}}
+}
+
+// plySize returns the size in bytes of a PLY scalar type, or zero if unknown.
+func plySize(typ string) int {
+	switch typ {
+	case "char", "uchar":
+		return 1
+	case "short", "ushort":
+		return 2
+	case "int", "uint", "float":
+		return 4
+	case "double":
+		return 8
+	}
+	return 0
+}
+
+// appendPLY appends x as the PLY scalar type typ, in binary little endian or ASCII.
+func appendPLY(b []byte, typ string, x float64, bin bool) []byte {
+	if !bin {
+		b = append(b, ' ')
+		if typ == "float" || typ == "double" {
+			return strconv.AppendFloat(b, x, 'g', -1, 64)
+		}
+		return strconv.AppendInt(b, int64(x), 10)
+	}
+	le := binary.LittleEndian
+	switch typ {
+	case "char":
+		return append(b, byte(int8(x)))
+	case "uchar":
+		return append(b, uint8(x))
+	case "short":
+		return le.AppendUint16(b, uint16(int16(x)))
+	case "ushort":
+		return le.AppendUint16(b, uint16(x))
+	case "int":
+		return le.AppendUint32(b, uint32(int32(x)))
+	case "uint":
+		return le.AppendUint32(b, uint32(x))
+	case "float":
+		return le.AppendUint32(b, math.Float32bits(float32(x)))
+	default:
+		return le.AppendUint64(b, math.Float64bits(x))
+	}
+}
+
+// WritePLY writes the leaves of h as a PLY mesh of output positions with the given vertex and
+// face properties. As with WriteOBJ only vertices used by a leaf are written, in the order of
+// Vertices, and faces are in the order of Indices.
+func (h *HTM) WritePLY(w io.Writer, opts PLYOptions) error {
+	indices := h.Indices()
+	used := make([]int, len(h.Vertices))
+	for _, i := range indices {
+		used[i] = 1
+	}
+	n := 0
+	for i, u := range used {
+		if u != 0 {
+			used[i] = n + 1
+			n++
+		}
+	}
+
+	vertex := []PLYProperty{
+		{"x", "float", func(i int) float64 { return h.PositionAt(i).X }},
+		{"y", "float", func(i int) float64 { return h.PositionAt(i).Y }},
+		{"z", "float", func(i int) float64 { return h.PositionAt(i).Z }},
+	}
+	if opts.Normals {
+		normals := h.Normals()
+		vertex = append(vertex,
+			PLYProperty{"nx", "float", func(i int) float64 { return normals[i].X }},
+			PLYProperty{"ny", "float", func(i int) float64 { return normals[i].Y }},
+			PLYProperty{"nz", "float", func(i int) float64 { return normals[i].Z }},
+		)
+	}
+	vertex = append(vertex, opts.Vertex...)
+	for _, p := range append(vertex, opts.Face...) {
+		if plySize(p.Type) == 0 {
+			return fmt.Errorf("property %v has unknown type %q", p.Name, p.Type)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	format := "ascii"
+	if opts.Binary {
+		format = "binary_little_endian"
+	}
+	fmt.Fprintf(bw, "ply\nformat %s 1.0\ncomment dasa.cc/htm\n", format)
+	fmt.Fprintf(bw, "element vertex %d\n", n)
+	for _, p := range vertex {
+		fmt.Fprintf(bw, "property %s %s\n", p.Type, p.Name)
+	}
+	fmt.Fprintf(bw, "element face %d\n", len(indices)/3)
+	bw.WriteString("property list uchar int vertex_indices\n")
+	for _, p := range opts.Face {
+		fmt.Fprintf(bw, "property %s %s\n", p.Type, p.Name)
+	}
+	bw.WriteString("end_header\n")
+
+	var b []byte
+	end := func() {
+		if opts.Binary {
+			bw.Write(b)
+		} else {
+			// drop the separator before the first value
+			bw.Write(b[1:])
+			bw.WriteByte('\n')
+		}
+		b = b[:0]
+	}
+	for i := range h.Vertices {
+		if used[i] == 0 {
+			continue
+		}
+		for _, p := range vertex {
+			b = appendPLY(b, p.Type, p.Value(i), opts.Binary)
+		}
+		end()
+	}
+	for idx, t := range h.Trees {
+		if t.Empty() || !t.Leaf() {
+			continue
+		}
+		b = appendPLY(b, "uchar", 3, opts.Binary)
+		for _, i := range t.Indices {
+			b = appendPLY(b, "int", float64(used[i]-1), opts.Binary)
+		}
+		for _, p := range opts.Face {
+			b = appendPLY(b, p.Type, p.Value(idx), opts.Binary)
+		}
+		end()
+	}
+	return bw.Flush()
+}
+
+// WriteSTL writes the leaves of h as a binary STL mesh of output positions with flat normals,
+// such as for printing a displaced surface. STL has no shared vertices or attributes.
+func (h *HTM) WriteSTL(w io.Writer) error {
+	positions, normals, _ := h.Flat()
+	le := binary.LittleEndian
+	b := make([]byte, 80, 84+len(positions)/3*50)
+	copy(b, "dasa.cc/htm")
+	b = le.AppendUint32(b, uint32(len(positions)/3))
+	put := func(v Vec3) {
+		f := v.Vec3f()
+		b = le.AppendUint32(b, math.Float32bits(f.X))
+		b = le.AppendUint32(b, math.Float32bits(f.Y))
+		b = le.AppendUint32(b, math.Float32bits(f.Z))
+	}
+	for k := 0; k < len(positions); k += 3 {
+		put(normals[k])
+		put(positions[k])
+		put(positions[k+1])
+		put(positions[k+2])
+		b = append(b, 0, 0)
+	}
+	_, err := w.Write(b)
+	return err
+}
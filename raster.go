@@ -0,0 +1,146 @@
+package htm
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// TriangleColor returns the colour of the leaf t drawn with the given coverage, or nil to not draw it.
+type TriangleColor func(t Tree, c Coverage) color.Color
+
+// RasterOptions select the triangles and colours drawn by Rasterize.
+type RasterOptions struct {
+	Tester Tester        // if set only leaves intersecting it are drawn, otherwise all leaves as Inside
+	Fill   TriangleColor // fill colour of each triangle, or nil for no fill
+	Edge   TriangleColor // wireframe colour of each triangle's edges, or nil for no wireframe
+	Shade  bool          // darken fills by how far they turn from the viewer
+}
+
+// Rasterize draws the leaves of h as triangles of output positions seen from +Z, projected onto x
+// and y as Image projects vertices, with a z-buffer hiding those behind others. Wireframe edges are
+// drawn over the fills of their own triangles but remain hidden by those in front.
+func Rasterize(h *HTM, size image.Point, opts RasterOptions) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+
+	type triangle struct {
+		idx int
+		cv  Coverage
+	}
+	var tris []triangle
+	if opts.Tester == nil {
+		for idx := range h.Leaves() {
+			tris = append(tris, triangle{idx, Inside})
+		}
+	} else {
+		for idx, cv := range h.Intersecting(opts.Tester) {
+			for leaf := range Leaves(h, idx) {
+				tris = append(tris, triangle{leaf, cv})
+			}
+		}
+	}
+
+	var max float64
+	for _, tr := range tris {
+		p0, p1, p2 := h.PositionsAt(tr.idx)
+		for _, p := range [3]Vec3{p0, p1, p2} {
+			max = math.Max(max, math.Max(math.Abs(p.X), math.Max(math.Abs(p.Y), math.Abs(p.Z))))
+		}
+	}
+	if max == 0 {
+		return m
+	}
+	// project returns pixel coordinates in x and y, and depth from 0 at the back to 1 at the front
+	project := func(p Vec3) Vec3 {
+		return Vec3{norm(p.X, max) * float64(size.X), norm(p.Y, max) * float64(size.Y), norm(p.Z, max)}
+	}
+
+	zbuf := make([]float64, size.X*size.Y)
+	for i := range zbuf {
+		zbuf[i] = math.Inf(-1)
+	}
+
+	fill := func(a, b, c Vec3, col color.Color) {
+		area := (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+		if area == 0 {
+			return
+		}
+		x0 := int(math.Max(math.Floor(math.Min(a.X, math.Min(b.X, c.X))), 0))
+		y0 := int(math.Max(math.Floor(math.Min(a.Y, math.Min(b.Y, c.Y))), 0))
+		x1 := int(math.Min(math.Ceil(math.Max(a.X, math.Max(b.X, c.X))), float64(size.X-1)))
+		y1 := int(math.Min(math.Ceil(math.Max(a.Y, math.Max(b.Y, c.Y))), float64(size.Y-1)))
+		for y := y0; y <= y1; y++ {
+			py := float64(y) + 0.5
+			for x := x0; x <= x1; x++ {
+				px := float64(x) + 0.5
+				// barycentric weights at the pixel center, negative outside
+				w0 := ((c.X-b.X)*(py-b.Y) - (c.Y-b.Y)*(px-b.X)) / area
+				w1 := ((a.X-c.X)*(py-c.Y) - (a.Y-c.Y)*(px-c.X)) / area
+				w2 := 1 - w0 - w1
+				if w0 < 0 || w1 < 0 || w2 < 0 {
+					continue
+				}
+				z := w0*a.Z + w1*b.Z + w2*c.Z
+				if i := y*size.X + x; z > zbuf[i] {
+					zbuf[i] = z
+					m.Set(x, y, col)
+				}
+			}
+		}
+	}
+
+	// edges pass the depth test within about two pixels of depth, so neighboring fills sampled at
+	// pixel centers don't hide them
+	bias := 4 / float64(size.X+size.Y)
+	line := func(a, b Vec3, col color.Color) {
+		n := int(math.Ceil(math.Max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y))))
+		for k := 0; k <= n; k++ {
+			t := 0.0
+			if n > 0 {
+				t = float64(k) / float64(n)
+			}
+			x, y := int(math.Floor(a.X+(b.X-a.X)*t)), int(math.Floor(a.Y+(b.Y-a.Y)*t))
+			if x < 0 || y < 0 || x >= size.X || y >= size.Y {
+				continue
+			}
+			if z := a.Z + (b.Z-a.Z)*t; z >= zbuf[y*size.X+x]-bias {
+				m.Set(x, y, col)
+			}
+		}
+	}
+
+	if opts.Fill != nil {
+		for _, tr := range tris {
+			col := opts.Fill(h.Trees[tr.idx], tr.cv)
+			if col == nil {
+				continue
+			}
+			p0, p1, p2 := h.PositionsAt(tr.idx)
+			if opts.Shade {
+				n, _ := p1.Sub(p0).Cross(p2.Sub(p0)).Normalized()
+				col = shade(col, 0.2+0.8*math.Max(n.Z, 0))
+			}
+			fill(project(p0), project(p1), project(p2), col)
+		}
+	}
+	if opts.Edge != nil {
+		for _, tr := range tris {
+			col := opts.Edge(h.Trees[tr.idx], tr.cv)
+			if col == nil {
+				continue
+			}
+			p0, p1, p2 := h.PositionsAt(tr.idx)
+			a, b, c := project(p0), project(p1), project(p2)
+			line(a, b, col)
+			line(b, c, col)
+			line(c, a, col)
+		}
+	}
+	return m
+}
+
+// shade scales the color channels of col by k, keeping its alpha.
+func shade(col color.Color, k float64) color.Color {
+	r, g, b, a := col.RGBA()
+	return color.RGBA64{uint16(float64(r) * k), uint16(float64(g) * k), uint16(float64(b) * k), uint16(a)}
+}
@@ -0,0 +1,160 @@
+package htm
+
+import "container/heap"
+
+// Priority returns the importance of the node at idx. Leaves with the highest priority are split
+// first and nodes with the lowest priority are merged first.
+type Priority func(h *HTM, idx int) float64
+
+// RefineStats reports the work done by a call to Refine.
+type RefineStats struct {
+	Splits, Merges int
+
+	// Triangles and Vertices are the number of leaves and referenced vertices after refinement.
+	Triangles, Vertices int
+}
+
+// Refine adapts the HTM to the best mesh for the given priority within a budget of triangles
+// (leaves) and vertices, where zero is unlimited. Leaves are split in order of highest priority
+// until the budget is reached. If the budget is exceeded, such as when it shrinks between calls,
+// nodes are merged in order of lowest priority. A node of lower priority than the next leaf to
+// split is also merged back to make room, so repeated calls track a changing priority.
+//
+// A leaf is split at most once per call and a node split by this call is not merged by it.
+func Refine(h *HTM, maxTriangles, maxVertices int, p Priority) RefineStats {
+	var st RefineStats
+
+	st.Vertices, _ = h.VertexCount()
+
+	splits := &nodeQueue{max: true}
+	merges := &nodeQueue{}
+	for idx, t := range h.Trees {
+		if t.Empty() {
+			continue
+		}
+		if t.Leaf() {
+			st.Triangles++
+			splits.items = append(splits.items, queueNode{idx, p(h, idx), 0})
+		} else if childrenAreLeaves(h, idx) {
+			merges.items = append(merges.items, queueNode{idx, p(h, idx), 0})
+		}
+	}
+	heap.Init(splits)
+	heap.Init(merges)
+
+	over := func(t, v int) bool {
+		return (maxTriangles != 0 && t > maxTriangles) || (maxVertices != 0 && v > maxVertices)
+	}
+
+	// changed records nodes split or merged by this call.
+	changed := make(map[int]bool)
+
+	// gen counts the times each slot is freed by a merge, so entries of freed leaves are not
+	// mistaken for new nodes reusing their slots.
+	gen := make(map[int]int)
+
+	// merge pops the lowest priority node that can still be merged and merges it.
+	merge := func() bool {
+		for merges.Len() > 0 {
+			n := heap.Pop(merges).(queueNode)
+			if changed[n.idx] || n.gen != gen[n.idx] || !childrenAreLeaves(h, n.idx) {
+				continue
+			}
+			for _, c := range h.Trees[n.idx].Children {
+				gen[c]++
+			}
+			released := mergeLeaves(h, n.idx)
+			changed[n.idx] = true
+			st.Merges++
+			st.Triangles -= 3
+			st.Vertices -= released
+
+			t := h.Trees[n.idx]
+			if t.Parent != -1 && !changed[t.Parent] && childrenAreLeaves(h, t.Parent) {
+				heap.Push(merges, queueNode{t.Parent, p(h, t.Parent), gen[t.Parent]})
+			}
+			return true
+		}
+		return false
+	}
+
+	for {
+		if over(st.Triangles, st.Vertices) {
+			if !merge() {
+				break
+			}
+			continue
+		}
+
+		// discard stale leaves from the top of the queue
+		for splits.Len() > 0 {
+			idx := splits.items[0].idx
+			if t := h.Trees[idx]; t.Empty() || !t.Leaf() || changed[idx] || splits.items[0].gen != gen[idx] {
+				heap.Pop(splits)
+				continue
+			}
+			break
+		}
+		if splits.Len() == 0 {
+			break
+		}
+		n := splits.items[0]
+
+		t := h.Trees[n.idx]
+		nv := 0
+		i0, i1, i2 := t.Indices[0], t.Indices[1], t.Indices[2]
+		for _, e := range [3][2]int{{i1, i2}, {i0, i2}, {i0, i1}} {
+			if h.Edges.mid(e[0], e[1]) == -1 {
+				nv++
+			}
+		}
+
+		if over(st.Triangles+3, st.Vertices+nv) {
+			// make room only at the expense of nodes less important than this one
+			if merges.Len() > 0 && merges.items[0].p < n.p && merge() {
+				continue
+			}
+			break
+		}
+
+		heap.Pop(splits)
+		SubDivide(h, n.idx, t.Level+1)
+		changed[n.idx] = true
+		st.Splits++
+		st.Triangles += 3
+		st.Vertices += nv
+
+		for _, c := range h.Trees[n.idx].Children {
+			heap.Push(splits, queueNode{c, p(h, c), gen[c]})
+		}
+	}
+
+	return st
+}
+
+type queueNode struct {
+	idx int
+	p   float64
+	gen int // generation of the slot at idx when queued
+}
+
+// nodeQueue is a priority queue of nodes, ordered lowest first unless max is set.
+type nodeQueue struct {
+	items []queueNode
+	max   bool
+}
+
+func (q *nodeQueue) Len() int { return len(q.items) }
+func (q *nodeQueue) Less(i, j int) bool {
+	if q.max {
+		return q.items[i].p > q.items[j].p
+	}
+	return q.items[i].p < q.items[j].p
+}
+func (q *nodeQueue) Swap(i, j int)      { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *nodeQueue) Push(x interface{}) { q.items = append(q.items, x.(queueNode)) }
+func (q *nodeQueue) Pop() interface{} {
+	n := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return n
+}
@@ -0,0 +1,74 @@
+package htm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Clone returns a deep copy of h sharing no memory with it, so either may be modified or read
+// concurrently with the other. Midpoint, Surface and OnChange are copied as is.
+func (h *HTM) Clone() *HTM {
+	c := *h
+	c.Edges = h.Edges.clone()
+	c.Vertices = append([]Vec3(nil), h.Vertices...)
+	c.Trees = append([]Tree(nil), h.Trees...)
+	c.refs = append([]int(nil), h.refs...)
+	c.freeTrees = append([]int(nil), h.freeTrees...)
+	c.freeVertices = append([]int(nil), h.freeVertices...)
+	return &c
+}
+
+// clone returns a deep copy of ed.
+func (ed *Edges) clone() *Edges {
+	c := &Edges{}
+	if ed.slice != nil {
+		if len(ed.slice) <= len(c.bootstrap) {
+			c.slice = c.bootstrap[:len(ed.slice)]
+		} else {
+			c.slice = make([]Edge, len(ed.slice))
+		}
+		copy(c.slice, ed.slice)
+	}
+	if ed.overflow != nil {
+		c.overflow = make(map[int][]Edge, len(ed.overflow))
+		for start, o := range ed.overflow {
+			c.overflow[start] = append([]Edge(nil), o...)
+		}
+	}
+	return c
+}
+
+// Shared holds an HTM read by any number of goroutines while modified by others. Readers Load an
+// immutable snapshot that stays consistent for as long as they hold it, and writers Update a
+// private copy that replaces the snapshot when done. Updates are serialized, and each copies the
+// whole HTM, so batch changes into as few updates as possible.
+//
+//	s := NewShared(h)
+//	go s.Update(func(h *HTM) { SubDivide(h, idx, 9) })
+//	mt := s.Load().Intersections(c)
+type Shared struct {
+	mu  sync.Mutex
+	cur atomic.Pointer[HTM]
+}
+
+// NewShared returns a Shared holding h, which must not be used directly afterwards.
+func NewShared(h *HTM) *Shared {
+	s := &Shared{}
+	s.cur.Store(h)
+	return s
+}
+
+// Load returns the current snapshot. It must not be modified; use Update instead.
+func (s *Shared) Load() *HTM {
+	return s.cur.Load()
+}
+
+// Update calls f with a copy of the current snapshot and publishes the result as the new snapshot.
+// Readers of previous snapshots are unaffected.
+func (s *Shared) Update(f func(h *HTM)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.cur.Load().Clone()
+	f(h)
+	s.cur.Store(h)
+}
@@ -0,0 +1,112 @@
+package htm
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Surface maps the unit direction of a vertex to its output position. Subdivision and queries
+// operate on directions while positions are derived for display.
+type Surface interface {
+	Position(v Vec3) Vec3
+}
+
+// Sphere is a sphere of the given radius.
+type Sphere struct {
+	Radius float64
+}
+
+func (s Sphere) Position(v Vec3) Vec3 {
+	return v.MulScalar(s.Radius)
+}
+
+// Ellipsoid is an oblate ellipsoid of revolution about the Z axis. A direction maps to the point
+// on the surface whose normal is that direction, as with geodetic latitude and longitude.
+type Ellipsoid struct {
+	Equatorial, Polar float64
+}
+
+// WGS84 is the reference ellipsoid of the World Geodetic System 1984 in meters.
+var WGS84 = Ellipsoid{Equatorial: 6378137, Polar: 6356752.314245}
+
+func (e Ellipsoid) Position(v Vec3) Vec3 {
+	a2, b2 := e.Equatorial*e.Equatorial, e.Polar*e.Polar
+	n := math.Sqrt(a2*(v.X*v.X+v.Y*v.Y) + b2*v.Z*v.Z)
+	if n == 0 {
+		return Vec3{}
+	}
+	return Vec3{X: a2 * v.X / n, Y: a2 * v.Y / n, Z: b2 * v.Z / n}
+}
+
+// Displaced offsets the positions of a surface along the vertex direction by a height, such as
+// from a heightfield or noise function. Surface may be nil for the unit sphere.
+type Displaced struct {
+	Surface Surface
+	Height  func(v Vec3) float64
+}
+
+func (d Displaced) Position(v Vec3) Vec3 {
+	p := v
+	if d.Surface != nil {
+		p = d.Surface.Position(v)
+	}
+	return p.Add(v.MulScalar(d.Height(v)))
+}
+
+// ImageHeight returns a height function sampling the luminance of an equirectangular heightfield,
+// mapped with the same UV coordinates as TexCoords and scaled so white is the given height.
+func ImageHeight(m image.Image, scale float64) func(v Vec3) float64 {
+	b := m.Bounds()
+	return func(v Vec3) float64 {
+		u := 0.5 + math.Atan2(v.Y, v.X)/(math.Pi*2)
+		t := 0.5 - math.Asin(math.Max(-1, math.Min(1, v.Z)))/math.Pi
+		x := b.Min.X + int(u*float64(b.Dx()))
+		y := b.Min.Y + int(t*float64(b.Dy()))
+		if x >= b.Max.X {
+			x = b.Max.X - 1
+		}
+		if y >= b.Max.Y {
+			y = b.Max.Y - 1
+		}
+		g := color.Gray16Model.Convert(m.At(x, y)).(color.Gray16)
+		return float64(g.Y) / 0xffff * scale
+	}
+}
+
+// PositionAt returns the output position of the vertex at the given index. Empty vertices
+// remain zero.
+func (h *HTM) PositionAt(i int) Vec3 {
+	v := h.Vertices[i]
+	if h.Surface == nil || (v.X == 0 && v.Y == 0 && v.Z == 0) {
+		return v
+	}
+	return h.Surface.Position(v)
+}
+
+// PositionsAt looks up the output positions of a node's vertices.
+func (h *HTM) PositionsAt(idx int) (p0, p1, p2 Vec3) {
+	i0, i1, i2 := h.IndicesAt(idx)
+	return h.PositionAt(i0), h.PositionAt(i1), h.PositionAt(i2)
+}
+
+// Positions returns the output position of every vertex, in the same order as Vertices so that
+// the result of Indices may be used with it.
+func (h *HTM) Positions() []Vec3 {
+	p := make([]Vec3, len(h.Vertices))
+	for i := range h.Vertices {
+		p[i] = h.PositionAt(i)
+	}
+	return p
+}
+
+// PositionsNotEmpty returns the output positions of VerticesNotEmpty.
+func (h *HTM) PositionsNotEmpty() []Vec3 {
+	var p []Vec3
+	for i, v := range h.Vertices {
+		if !(v.X == 0 && v.Y == 0 && v.Z == 0) {
+			p = append(p, h.PositionAt(i))
+		}
+	}
+	return p
+}
@@ -1,15 +1,11 @@
 package htm
 
-import (
-	"math"
-
-	"github.com/azul3d/engine/lmath"
-)
+import "math"
 
 // TexCoords returns a slice of UV coordinates for texture mapping.
 // TODO(d) seam does not wrap correctly.
 // TODO(d) allow user to declare which axis is up.
-func TexCoords(verts []lmath.Vec3) []float32 {
+func TexCoords(verts []Vec3) []float32 {
 	var tc []float32
 	for _, v0 := range verts {
 		u := 0.5 + math.Atan2(v0.Y, v0.X)/(math.Pi*2)
@@ -22,7 +18,7 @@ func TexCoords(verts []lmath.Vec3) []float32 {
 // TexCoordsPlanar returns a slice of UV coordinates mapped against
 // a 2-dimensional plane.
 // TODO(d) allow user to declare up axis.
-func TexCoordsPlanar(verts []lmath.Vec3) []float32 {
+func TexCoordsPlanar(verts []Vec3) []float32 {
 	var xlo, xhi, zlo, zhi float64
 	for _, v0 := range verts {
 		if v0.X < xlo {
@@ -0,0 +1,121 @@
+package htm
+
+import "math"
+
+// epsilon is the tolerance of equal and Vec3.Equals.
+const epsilon = 1e-8
+
+// almostEqual reports if a and b are equal within the given absolute or relative tolerance.
+func almostEqual(a, b, tolerance float64) bool {
+	return a == b || math.Abs(a-b) <= tolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// equal reports if a and b are equal within a tolerance of epsilon.
+func equal(a, b float64) bool {
+	return almostEqual(a, b, epsilon)
+}
+
+// Vec3 is a vector of three components, used for vertices and positions.
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// Vec3Zero is the zero vector, which marks an empty vertex.
+var Vec3Zero = Vec3{}
+
+// Add returns a + b.
+func (a Vec3) Add(b Vec3) Vec3 {
+	return Vec3{a.X + b.X, a.Y + b.Y, a.Z + b.Z}
+}
+
+// Sub returns a - b.
+func (a Vec3) Sub(b Vec3) Vec3 {
+	return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z}
+}
+
+// MulScalar returns a scaled by b.
+func (a Vec3) MulScalar(b float64) Vec3 {
+	return Vec3{a.X * b, a.Y * b, a.Z * b}
+}
+
+// DivScalar returns a divided by b.
+func (a Vec3) DivScalar(b float64) Vec3 {
+	return Vec3{a.X / b, a.Y / b, a.Z / b}
+}
+
+// Dot returns the dot product of a and b.
+func (a Vec3) Dot(b Vec3) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
+
+// Cross returns the cross product of a and b.
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{
+		a.Y*b.Z - b.Y*a.Z,
+		b.X*a.Z - a.X*b.Z,
+		a.X*b.Y - b.X*a.Y,
+	}
+}
+
+// LengthSq returns the squared length of a.
+func (a Vec3) LengthSq() float64 {
+	return a.X*a.X + a.Y*a.Y + a.Z*a.Z
+}
+
+// Length returns the length of a.
+func (a Vec3) Length() float64 {
+	return math.Sqrt(a.X*a.X + a.Y*a.Y + a.Z*a.Z)
+}
+
+// Normalized returns a scaled to unit length, or the zero vector and false if a has no length.
+func (a Vec3) Normalized() (Vec3, bool) {
+	length := math.Sqrt(a.X*a.X + a.Y*a.Y + a.Z*a.Z)
+	if equal(length, 0) {
+		return Vec3Zero, false
+	}
+	return Vec3{a.X / length, a.Y / length, a.Z / length}, true
+}
+
+// AlmostEquals reports if each component of a and b are equal within the given absolute or
+// relative tolerance.
+func (a Vec3) AlmostEquals(b Vec3, tolerance float64) bool {
+	return almostEqual(a.X, b.X, tolerance) && almostEqual(a.Y, b.Y, tolerance) && almostEqual(a.Z, b.Z, tolerance)
+}
+
+// Equals reports if a and b are equal within a tolerance of 1e-8.
+func (a Vec3) Equals(b Vec3) bool {
+	return a.AlmostEquals(b, epsilon)
+}
+
+// Vec3f is a vector of three float32 components for data bound for the GPU.
+type Vec3f struct {
+	X, Y, Z float32
+}
+
+// Vec3f returns a with float32 components.
+func (a Vec3) Vec3f() Vec3f {
+	return Vec3f{float32(a.X), float32(a.Y), float32(a.Z)}
+}
+
+// Vec3 returns a with float64 components.
+func (a Vec3f) Vec3() Vec3 {
+	return Vec3{float64(a.X), float64(a.Y), float64(a.Z)}
+}
+
+// Vec3fs converts vs to float32 components.
+func Vec3fs(vs []Vec3) []Vec3f {
+	fs := make([]Vec3f, len(vs))
+	for i, v := range vs {
+		fs[i] = v.Vec3f()
+	}
+	return fs
+}
+
+// Float32s returns the components of vs interleaved as X, Y, Z for upload as a vertex buffer.
+func Float32s(vs []Vec3) []float32 {
+	fs := make([]float32, 0, len(vs)*3)
+	for _, v := range vs {
+		fs = append(fs, float32(v.X), float32(v.Y), float32(v.Z))
+	}
+	return fs
+}
@@ -0,0 +1,161 @@
+package htm
+
+import (
+	"fmt"
+	"iter"
+)
+
+// View is a read-only HTM read in place from its binary encoding, such as a file written with
+// WriteTo and opened with OpenView, which several processes may map and share without decoding it
+// to the heap. Node indices are those of the encoded HTM.
+type View struct {
+	data  []byte
+	hd    header
+	close func() error
+}
+
+// NewView returns a View of data produced by MarshalBinary. The data is verified but not copied,
+// and must not be modified while the View is in use.
+func NewView(data []byte) (*View, error) {
+	hd, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	v := &View{data: data, hd: hd}
+	for idx := 0; idx < hd.trees; idx++ {
+		if err := hd.check(v.Tree(idx)); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTrees(hd.trees, v.Tree); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Close releases the data of a View returned by OpenView. The View must not be used afterwards.
+func (v *View) Close() error {
+	if v.close == nil {
+		return nil
+	}
+	err := v.close()
+	v.data, v.close = nil, nil
+	return err
+}
+
+// Roots returns the number of root nodes, which occupy the first indices.
+func (v *View) Roots() int { return v.hd.roots }
+
+// TreeCount returns the number of trees including empty trees.
+func (v *View) TreeCount() int { return v.hd.trees }
+
+// VertexCount returns the number of vertices including empty vertices.
+func (v *View) VertexCount() int { return v.hd.vertices }
+
+// Tree returns the node at idx.
+func (v *View) Tree(idx int) Tree {
+	return decodeTree(v.data[v.hd.treeOff+idx*treeSize:], idx)
+}
+
+// Vertex returns the vertex at index i.
+func (v *View) Vertex(i int) Vec3 {
+	return decodeVertex(v.data[v.hd.vertexOff+i*vertexSize:])
+}
+
+// VerticesAt looks up a node's vertices from its indices.
+func (v *View) VerticesAt(idx int) (v0, v1, v2 Vec3) {
+	t := v.Tree(idx)
+	return v.Vertex(t.Indices[0]), v.Vertex(t.Indices[1]), v.Vertex(t.Indices[2])
+}
+
+// LookupByCart returns the smallest node containing p.
+func (v *View) LookupByCart(p Vec3) (Tree, error) {
+	for idx := 0; idx < v.hd.roots; idx++ {
+		t := v.Tree(idx)
+		if t.Empty() {
+			continue
+		}
+		if v0, v1, v2 := v.VerticesAt(idx); !inside(v0, v1, v2, p) {
+			continue
+		}
+		for !t.Leaf() {
+			next := -1
+			for _, c := range t.Children {
+				if v0, v1, v2 := v.VerticesAt(c); inside(v0, v1, v2, p) {
+					next = c
+					break
+				}
+			}
+			if next == -1 {
+				break
+			}
+			t = v.Tree(next)
+		}
+		if t.Leaf() {
+			return t, nil
+		}
+	}
+	return Tree{}, fmt.Errorf("Failed to lookup triangle by given cartesian coordinates: %v", p)
+}
+
+// Intersections returns the node indices that fully or partially match t, as HTM.Intersections.
+func (v *View) Intersections(t Tester) []int {
+	var mt []int
+	for idx := range v.Intersecting(t) {
+		mt = append(mt, idx)
+	}
+	return mt
+}
+
+// Intersecting returns an iterator over the nodes of Intersections along with their coverage.
+func (v *View) Intersecting(t Tester) iter.Seq2[int, Coverage] {
+	return func(yield func(int, Coverage) bool) {
+		for idx := 0; idx < v.hd.roots; idx++ {
+			if !v.Tree(idx).Empty() && !v.intersecting(idx, t, yield) {
+				return
+			}
+		}
+	}
+}
+
+func (v *View) intersecting(idx int, t Tester, yield func(int, Coverage) bool) bool {
+	tr := v.Tree(idx)
+	cv := t.Test(v.VerticesAt(idx))
+	if cv == Inside || (cv == Partial && tr.Leaf()) {
+		return yield(idx, cv)
+	}
+	if tr.Leaf() {
+		return true
+	}
+	for _, c := range tr.Children {
+		if !v.intersecting(c, t, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaves returns an iterator over the leaves at or below each of the given nodes, depth first,
+// as Leaves and Iter do for an HTM.
+func (v *View) Leaves(positions ...int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for _, idx := range positions {
+			if !v.leaves(idx, yield) {
+				return
+			}
+		}
+	}
+}
+
+func (v *View) leaves(idx int, yield func(int) bool) bool {
+	t := v.Tree(idx)
+	if t.Leaf() {
+		return yield(idx)
+	}
+	for _, c := range t.Children {
+		if !v.leaves(c, yield) {
+			return false
+		}
+	}
+	return true
+}